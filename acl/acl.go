@@ -0,0 +1,440 @@
+// Package acl compiles declarative rules, loaded from a JSON or YAML
+// configuration, into cache.ACL values deciding which entries are
+// written to the caches.
+//
+// The rules are evaluated in order and the first rule matching an
+// entry decides whether it is allowed or denied.  A rule matches when
+// all its conditions match.  The entries matched by no rule get the
+// default action, which is to deny them unless configured otherwise.
+//
+//	default: deny
+//	rules:
+//	  - {action: deny, gecos: "(?i)contractor"}
+//	  - {action: allow, name: "admin-*"}
+//	  - {action: allow, uid: 1000-1999, group: sre}
+//	  - {action: allow, map: [group]}
+//
+// The group conditions need the groups of the source, which the source
+// returned by Policy.Source loads before the passwd cache is filled:
+//
+//	cm := nsscache.NewCaches(
+//		nsscache.Option{CacheName: "passwd", Option: cache.WithACL(p.ACL())},
+//		nsscache.Option{CacheName: "shadow", Option: cache.WithACL(p.ACL())},
+//	)
+//	err := cm.FillCaches(p.Source(src))
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source"
+	"gopkg.in/yaml.v3"
+)
+
+// The actions of the rules.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Config is the declarative configuration of a Policy.
+type Config struct {
+	Default string `yaml:"default" json:"default"` // Action of the entries matched by no rule
+	Rules   []Rule `yaml:"rules" json:"rules"`     // Rules, in order
+}
+
+// Rule allows or denies the entries matching all its conditions.  The
+// empty conditions match every entry.
+type Rule struct {
+	Action string   `yaml:"action" json:"action"` // allow or deny
+	Map    []string `yaml:"map" json:"map"`       // Maps of the entry: passwd, shadow or group
+	Name   string   `yaml:"name" json:"name"`     // Glob matching the name
+	UID    string   `yaml:"uid" json:"uid"`       // UID or range of UIDs, e.g. 1000-1999
+	GID    string   `yaml:"gid" json:"gid"`       // GID or range of GIDs, of the user or the group
+	Group  string   `yaml:"group" json:"group"`   // Group the user is a member of
+	GECOS  string   `yaml:"gecos" json:"gecos"`   // Regular expression matching the GECOS
+}
+
+// String returns a short description of the rule.
+func (r Rule) String() string {
+	parts := []string{r.Action}
+	if len(r.Map) > 0 {
+		parts = append(parts, "map="+strings.Join(r.Map, ","))
+	}
+	for _, c := range []struct{ key, value string }{
+		{"name", r.Name},
+		{"uid", r.UID},
+		{"gid", r.GID},
+		{"group", r.Group},
+		{"gecos", r.GECOS},
+	} {
+		if c.value != "" {
+			parts = append(parts, c.key+"="+c.value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// idRange is an inclusive range of IDs.
+type idRange struct {
+	min, max uint64
+}
+
+func parseRange(s string) (*idRange, error) {
+	if s == "" {
+		return nil, nil
+	}
+	r := idRange{max: 1<<32 - 1}
+	lo, hi := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	var err error
+	if lo != "" {
+		if r.min, err = strconv.ParseUint(strings.TrimSpace(lo), 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid range %q", s)
+		}
+	}
+	if hi != "" {
+		if r.max, err = strconv.ParseUint(strings.TrimSpace(hi), 10, 32); err != nil {
+			return nil, fmt.Errorf("invalid range %q", s)
+		}
+	}
+	if r.min > r.max {
+		return nil, fmt.Errorf("invalid range %q", s)
+	}
+	return &r, nil
+}
+
+func (r *idRange) contains(id uint32) bool {
+	return uint64(id) >= r.min && uint64(id) <= r.max
+}
+
+// rule is a compiled Rule.
+type rule struct {
+	Rule
+	allow bool
+	maps  map[string]bool
+	uid   *idRange
+	gid   *idRange
+	gecos *regexp.Regexp
+}
+
+// errNoGroups is the error of the group conditions evaluated before
+// the groups are set.
+var errNoGroups = errors.New("the groups are not loaded")
+
+// Policy is a compiled Config.
+type Policy struct {
+	rules []rule
+	allow bool
+
+	mu      sync.Mutex
+	groups  map[string]*cache.GroupEntry
+	decided map[string]Decision // Decisions on the passwd entries of the last fill
+	filling bool                // Whether the last entry evaluated was a passwd entry
+}
+
+// Parse compiles the JSON or YAML configuration.
+func Parse(b []byte) (*Policy, error) {
+	var cfg Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing rules: %w", err)
+	}
+	return Compile(cfg)
+}
+
+// Load compiles the JSON or YAML configuration in the file.
+func Load(fpath string) (*Policy, error) {
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	p, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fpath, err)
+	}
+	return p, nil
+}
+
+func parseAction(a string) (bool, error) {
+	switch strings.ToLower(a) {
+	case Allow:
+		return true, nil
+	case Deny:
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid action %q", a)
+	}
+}
+
+// Compile compiles the configuration into a Policy.
+func Compile(cfg Config) (*Policy, error) {
+	p := Policy{decided: map[string]Decision{}}
+	if cfg.Default != "" {
+		allow, err := parseAction(cfg.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+		p.allow = allow
+	}
+
+	for i, r := range cfg.Rules {
+		c, err := compileRule(r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		p.rules = append(p.rules, c)
+	}
+	return &p, nil
+}
+
+func compileRule(r Rule) (rule, error) {
+	c := rule{Rule: r}
+	var err error
+	if c.allow, err = parseAction(r.Action); err != nil {
+		return c, err
+	}
+	if len(r.Map) > 0 {
+		c.maps = map[string]bool{}
+		for _, m := range r.Map {
+			switch m {
+			case "passwd", "shadow", "group":
+				c.maps[m] = true
+			default:
+				return c, fmt.Errorf("invalid map %q", m)
+			}
+		}
+	}
+	if _, err := path.Match(r.Name, ""); err != nil {
+		return c, fmt.Errorf("invalid name %q: %w", r.Name, err)
+	}
+	if c.uid, err = parseRange(r.UID); err != nil {
+		return c, fmt.Errorf("uid: %w", err)
+	}
+	if c.gid, err = parseRange(r.GID); err != nil {
+		return c, fmt.Errorf("gid: %w", err)
+	}
+	if r.GECOS != "" {
+		if c.gecos, err = regexp.Compile(r.GECOS); err != nil {
+			return c, fmt.Errorf("gecos: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// SetGroups sets the group entries the group conditions are evaluated
+// against, and forgets the decisions on the passwd entries reused for
+// the shadow entries.  A user is a member of a group if it is listed
+// in its members or if it is its primary group.  Other entries are
+// ignored.  Until the groups are set, the rules with a group condition
+// deny the passwd entries they are evaluated on.
+func (p *Policy) SetGroups(entries []cache.Entry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.groups = map[string]*cache.GroupEntry{}
+	for _, e := range entries {
+		if g, ok := e.(*cache.GroupEntry); ok {
+			p.groups[g.Name] = g
+		}
+	}
+	p.decided = map[string]Decision{}
+}
+
+// Source returns a source.Source filling the caches from src, which
+// sets the groups of the policy from the group map of src, read without
+// any ACL, at the start of every fill of the passwd cache.  The next
+// fill of the group cache adds the same group entries instead of
+// reading them again, so that the group cache and the decisions agree.
+func (p *Policy) Source(src source.Source) source.Source {
+	return &policySource{Source: src, p: p}
+}
+
+// policySource is a source.Source setting the groups of a policy.
+type policySource struct {
+	source.Source
+	p *Policy
+
+	mu     sync.Mutex
+	groups []cache.Entry // Groups read by the last passwd fill
+}
+
+// FillPasswdCache sets the groups of the policy and fills the passwd
+// cache.
+func (s *policySource) FillPasswdCache(c *cache.Cache) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	groups := cache.NewCache()
+	if err := s.Source.FillGroupCache(groups); err != nil {
+		return fmt.Errorf("reading the groups of the ACL: %w", err)
+	}
+	s.groups = groups.Entries()
+	s.p.SetGroups(s.groups)
+	return s.Source.FillPasswdCache(c)
+}
+
+// FillGroupCache fills the group cache with the groups read by the
+// last passwd fill, or from the source if there are none.
+func (s *policySource) FillGroupCache(c *cache.Cache) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.groups == nil {
+		return s.Source.FillGroupCache(c)
+	}
+	c.Add(s.groups...)
+	s.groups = nil
+	return nil
+}
+
+// Decision is the result of the evaluation of an entry.
+type Decision struct {
+	Allowed bool
+	Rule    int    // Number of the rule, starting from 1, or 0 for the default action
+	Reason  string // Description of the rule, or why no rule was evaluated
+}
+
+func (d Decision) String() string {
+	verb := "denied"
+	if d.Allowed {
+		verb = "allowed"
+	}
+	if d.Rule == 0 {
+		if d.Reason != "" {
+			return fmt.Sprintf("%s (%s)", verb, d.Reason)
+		}
+		return verb + " by default"
+	}
+	return fmt.Sprintf("%s by rule %d (%s)", verb, d.Rule, d.Reason)
+}
+
+// Explain evaluates the entry and returns the decision.  The shadow
+// entries get the decision of the passwd entry of the same name
+// evaluated during the last fill of the passwd cache, and are denied
+// without one since the rules on users cannot be evaluated on them;
+// FillCaches fills the passwd cache first.  A passwd entry evaluated
+// after entries of other maps starts a new fill, and the decisions of
+// the previous one are forgotten.  A rule whose conditions cannot be
+// evaluated denies the entry, with the error in the reason.
+func (p *Policy) Explain(e cache.Entry) Decision {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pe, isUser := e.(*cache.PasswdEntry)
+	if isUser && !p.filling {
+		p.decided = map[string]Decision{}
+	}
+	p.filling = isUser
+
+	if se, ok := e.(*cache.ShadowEntry); ok {
+		if d, ok := p.decided[se.Name]; ok {
+			return d
+		}
+		return Decision{Reason: "no passwd entry"}
+	}
+
+	d := Decision{Allowed: p.allow}
+	for i, r := range p.rules {
+		ok, err := p.match(r, e)
+		if err != nil {
+			d = Decision{Rule: i + 1, Reason: fmt.Sprintf("%s: %v", r, err)}
+			break
+		}
+		if ok {
+			d = Decision{Allowed: r.allow, Rule: i + 1, Reason: r.String()}
+			break
+		}
+	}
+	if isUser {
+		p.decided[pe.Name] = d
+	}
+	return d
+}
+
+// ACL returns the cache.ACL applying the policy.
+func (p *Policy) ACL() cache.ACL {
+	return func(e cache.Entry) bool {
+		return p.Explain(e).Allowed
+	}
+}
+
+// match returns true if the entry matches all the conditions of the
+// rule.  The conditions on fields the entry does not have do not
+// match.  The group condition cannot be evaluated before the groups
+// are set.
+func (p *Policy) match(r rule, e cache.Entry) (bool, error) {
+	var m string
+	switch e.(type) {
+	case *cache.PasswdEntry:
+		m = "passwd"
+	case *cache.ShadowEntry:
+		m = "shadow"
+	case *cache.GroupEntry:
+		m = "group"
+	}
+	if r.maps != nil && !r.maps[m] {
+		return false, nil
+	}
+	if r.Name != "" {
+		if ok, _ := path.Match(r.Name, e.Column(0)); !ok {
+			return false, nil
+		}
+	}
+
+	pe, isUser := e.(*cache.PasswdEntry)
+	if (r.uid != nil || r.Group != "" || r.gecos != nil) && !isUser {
+		return false, nil
+	}
+	if r.uid != nil && !r.uid.contains(pe.UID) {
+		return false, nil
+	}
+	if r.gid != nil {
+		switch v := e.(type) {
+		case *cache.PasswdEntry:
+			if !r.gid.contains(v.GID) {
+				return false, nil
+			}
+		case *cache.GroupEntry:
+			if !r.gid.contains(v.GID) {
+				return false, nil
+			}
+		default:
+			return false, nil
+		}
+	}
+	if r.Group != "" {
+		if p.groups == nil {
+			return false, errNoGroups
+		}
+		if !p.member(pe, r.Group) {
+			return false, nil
+		}
+	}
+	if r.gecos != nil && !r.gecos.MatchString(pe.GECOS) {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (p *Policy) member(u *cache.PasswdEntry, group string) bool {
+	g, ok := p.groups[group]
+	if !ok {
+		return false
+	}
+	if g.GID == u.GID {
+		return true
+	}
+	for _, m := range g.Mem {
+		if m == u.Name {
+			return true
+		}
+	}
+	return false
+}
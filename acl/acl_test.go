@@ -0,0 +1,208 @@
+package acl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	nsscache "github.com/MiLk/nsscache-go"
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRules = `
+default: deny
+rules:
+  - {action: deny, gecos: "(?i)contractor"}
+  - {action: allow, name: "admin-*"}
+  - {action: allow, uid: 1000-1999, group: sre}
+  - {action: deny, uid: 0}
+  - {action: allow, map: [group], gid: 1000-}
+`
+
+var (
+	admin      = &cache.PasswdEntry{Name: "admin-foo", Passwd: "x", UID: 500, GID: 500}
+	foo        = &cache.PasswdEntry{Name: "foo", Passwd: "x", UID: 1000, GID: 1000, GECOS: "Foo"}
+	bar        = &cache.PasswdEntry{Name: "bar", Passwd: "x", UID: 1001, GID: 2000}
+	baz        = &cache.PasswdEntry{Name: "baz", Passwd: "x", UID: 1002, GID: 1002}
+	contractor = &cache.PasswdEntry{Name: "admin-qux", Passwd: "x", UID: 1003, GID: 2000, GECOS: "Qux (Contractor)"}
+	sre        = &cache.GroupEntry{Name: "sre", GID: 2000, Mem: []string{"foo"}}
+	wheel      = &cache.GroupEntry{Name: "wheel", GID: 10, Mem: []string{"foo"}}
+)
+
+func TestPolicy(t *testing.T) {
+	p, err := Parse([]byte(testRules))
+	assert.Nil(t, err)
+	p.SetGroups([]cache.Entry{sre, wheel})
+
+	for _, tc := range []struct {
+		e        cache.Entry
+		expected string
+	}{
+		{admin, "allowed by rule 2 (allow name=admin-*)"},
+		{foo, "allowed by rule 3 (allow uid=1000-1999 group=sre)"},
+		{bar, "allowed by rule 3 (allow uid=1000-1999 group=sre)"},
+		{baz, "denied by default"},
+		{contractor, "denied by rule 1 (deny gecos=(?i)contractor)"},
+		{&cache.PasswdEntry{Name: "root"}, "denied by rule 4 (deny uid=0)"},
+		{sre, "allowed by rule 5 (allow map=group gid=1000-)"},
+		{wheel, "denied by default"},
+		{&cache.ShadowEntry{Name: "admin-bar"}, "denied (no passwd entry)"},
+	} {
+		assert.Equal(t, tc.expected, p.Explain(tc.e).String(), tc.e.Column(0))
+	}
+
+	// The shadow entries follow the passwd entries
+	assert.Equal(t, "allowed by rule 3 (allow uid=1000-1999 group=sre)", p.Explain(&cache.ShadowEntry{Name: "foo"}).String())
+	assert.Equal(t, "denied by rule 1 (deny gecos=(?i)contractor)", p.Explain(&cache.ShadowEntry{Name: "admin-qux"}).String())
+}
+
+func TestPolicy_ACL(t *testing.T) {
+	p, err := Parse([]byte(`{"default": "allow", "rules": [{"action": "deny", "gid": "0-999"}]}`))
+	assert.Nil(t, err)
+
+	c := cache.NewCache(cache.WithACL(p.ACL()))
+	c.Add(admin, foo, &cache.GroupEntry{Name: "root", GID: 0}, sre)
+
+	var b bytes.Buffer
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:1000:Foo::\nsre:x:2000:foo\n", b.String())
+}
+
+func TestPolicy_Groups(t *testing.T) {
+	p, err := Parse([]byte(testRules))
+	assert.Nil(t, err)
+
+	// The group rules cannot be evaluated until the groups are set
+	assert.Equal(t, "denied by rule 3 (allow uid=1000-1999 group=sre: the groups are not loaded)", p.Explain(foo).String())
+	assert.Equal(t, "allowed by rule 2 (allow name=admin-*)", p.Explain(admin).String())
+
+	p.SetGroups([]cache.Entry{sre})
+	assert.True(t, p.Explain(foo).Allowed)
+	assert.True(t, p.Explain(&cache.ShadowEntry{Name: "foo"}).Allowed)
+
+	// The decisions of the previous groups are forgotten
+	p.SetGroups(nil)
+	assert.Equal(t, "denied (no passwd entry)", p.Explain(&cache.ShadowEntry{Name: "foo"}).String())
+	assert.Equal(t, "denied by default", p.Explain(foo).String())
+}
+
+func TestPolicy_Shadow(t *testing.T) {
+	p, err := Parse([]byte(`{"default": "allow", "rules": [{"action": "deny", "uid": "0-999"}]}`))
+	assert.Nil(t, err)
+	root := &cache.PasswdEntry{Name: "root", Passwd: "x", UID: 0, GID: 0}
+	rootShadow := &cache.ShadowEntry{Name: "root", Passwd: "$6$hash"}
+
+	// Without a passwd entry, the rules on the UID cannot deny the hash
+	assert.Equal(t, "denied (no passwd entry)", p.Explain(rootShadow).String())
+
+	assert.False(t, p.Explain(root).Allowed)
+	assert.True(t, p.Explain(foo).Allowed)
+	assert.False(t, p.Explain(rootShadow).Allowed)
+	assert.True(t, p.Explain(&cache.ShadowEntry{Name: "foo"}).Allowed)
+
+	// A new passwd fill forgets the users which left
+	assert.True(t, p.Explain(bar).Allowed)
+	assert.Equal(t, "denied (no passwd entry)", p.Explain(&cache.ShadowEntry{Name: "foo"}).String())
+	assert.True(t, p.Explain(&cache.ShadowEntry{Name: "bar"}).Allowed)
+}
+
+// testSource fills the caches with its entries, counting the fills of
+// the group cache.
+type testSource struct {
+	passwd, shadow, group []cache.Entry
+	groupFills            int
+}
+
+func (s *testSource) FillPasswdCache(c *cache.Cache) error {
+	c.Add(s.passwd...)
+	return nil
+}
+
+func (s *testSource) FillShadowCache(c *cache.Cache) error {
+	c.Add(s.shadow...)
+	return nil
+}
+
+func (s *testSource) FillGroupCache(c *cache.Cache) error {
+	s.groupFills++
+	c.Add(s.group...)
+	return nil
+}
+
+func TestPolicy_Source(t *testing.T) {
+	p, err := Parse([]byte(testRules))
+	assert.Nil(t, err)
+	src := &testSource{
+		passwd: []cache.Entry{foo, bar, baz},
+		shadow: []cache.Entry{
+			&cache.ShadowEntry{Name: "foo", Passwd: "*"},
+			&cache.ShadowEntry{Name: "bar", Passwd: "*"},
+			&cache.ShadowEntry{Name: "baz", Passwd: "*"},
+		},
+		group: []cache.Entry{sre},
+	}
+
+	fill := func() string {
+		cm := nsscache.NewCaches(
+			nsscache.Option{CacheName: "passwd", Option: cache.WithACL(p.ACL())},
+			nsscache.Option{CacheName: "shadow", Option: cache.WithACL(p.ACL())},
+		)
+		assert.Nil(t, cm.FillCaches(p.Source(src)))
+		var b bytes.Buffer
+		for _, name := range []string{"passwd", "shadow", "group"} {
+			_, err := cm[name].WriteTo(&b)
+			assert.Nil(t, err)
+		}
+		return b.String()
+	}
+
+	expected := "foo:x:1000:1000:Foo::\nbar:x:1001:2000:::\nfoo:*:::::::\nbar:*:::::::\nsre:x:2000:foo\n"
+	assert.Equal(t, expected, fill())
+	assert.Equal(t, 1, src.groupFills)
+
+	// The groups and the decisions follow the source
+	src.group = []cache.Entry{&cache.GroupEntry{Name: "sre", GID: 2000, Mem: []string{"baz"}}}
+	expected = "bar:x:1001:2000:::\nbaz:x:1002:1002:::\nbar:*:::::::\nbaz:*:::::::\nsre:x:2000:baz\n"
+	assert.Equal(t, expected, fill())
+	assert.Equal(t, 2, src.groupFills)
+
+	cm := nsscache.NewCaches()
+	assert.Nil(t, p.Source(src).FillGroupCache(cm["group"]))
+	assert.Equal(t, 3, src.groupFills)
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "acl.yaml")
+	assert.Nil(t, ioutil.WriteFile(fpath, []byte("rules:\n  - {action: allow, uid: 1000}\n"), 0644))
+	p, err := Load(fpath)
+	assert.Nil(t, err)
+	assert.True(t, p.Explain(foo).Allowed)
+	assert.False(t, p.Explain(bar).Allowed)
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.NotNil(t, err)
+
+	for rules, expected := range map[string]string{
+		"default: maybe":                           "default: invalid action \"maybe\"",
+		"rules: [{action: permit}]":                "rule 1: invalid action \"permit\"",
+		"rules: [{action: allow, map: [hosts]}]":   "rule 1: invalid map \"hosts\"",
+		"rules: [{action: allow, name: '[a'}]":     "rule 1: invalid name \"[a\": syntax error in pattern",
+		"rules: [{action: allow, uid: 2000-1000}]": "rule 1: uid: invalid range \"2000-1000\"",
+		"rules: [{action: allow, gid: foo}]":       "rule 1: gid: invalid range \"foo\"",
+		"rules: [{action: allow, gecos: '('}]":     "rule 1: gecos: error parsing regexp: missing closing ): `(`",
+	} {
+		assert.Nil(t, ioutil.WriteFile(fpath, []byte(rules), 0644))
+		_, err := Load(fpath)
+		if assert.NotNil(t, err, rules) {
+			assert.Equal(t, fpath+": "+expected, err.Error())
+		}
+	}
+}
@@ -5,10 +5,8 @@
 package cache
 
 import (
-	"bytes"
-	"fmt"
+	"encoding/json"
 	"io"
-	"sort"
 )
 
 // ACL specifies a function which will return true if the entry is
@@ -23,7 +21,11 @@ type Option func(c *Cache)
 // WithACL is a convenience function to obtain an Option function for
 // the specified ACL function.
 func WithACL(a ACL) Option {
-	return func(c *Cache) { c.acls = append(c.acls, a) }
+	return func(c *Cache) {
+		c.pipeline = append(c.pipeline, func(e Entry) (Entry, bool) {
+			return e, a(e)
+		})
+	}
 }
 
 // NewCache returns a new cache struct initialized with any provided
@@ -39,10 +41,16 @@ func NewCache(opts ...Option) *Cache {
 // Cache is an in-memory struct representing the cache to be used by
 // libnss-cache.
 type Cache struct {
-	entries []Entry // Entries contained in the cache
-	acls    []ACL
+	entries  []Entry // Entries contained in the cache
+	pipeline []step  // ACLs and transforms, in order
+	less     Less    // Order of the entries written, insertion order if nil
+	order    []Entry // Entries in the order of less, sorted once until they change
+	offsets  []int64 // Offsets of the entries recorded by the last WriteTo
 }
 
+// step is an ACL or a transform applied to the entries added.
+type step func(e Entry) (Entry, bool)
+
 // Add adds new entries to the cache.
 func (c *Cache) Add(es ...Entry) {
 	for _, e := range es {
@@ -52,64 +60,68 @@ func (c *Cache) Add(es ...Entry) {
 
 // addOne adds a new entry to the cache.
 func (c *Cache) addOne(e Entry) {
-	for _, acl := range c.acls {
-		if !acl(e) {
+	for _, step := range c.pipeline {
+		var ok bool
+		if e, ok = step(e); !ok {
 			return
 		}
 	}
 
 	c.entries = append(c.entries, e)
+	c.order = nil
+	c.offsets = nil
 }
 
-// WriteTo writes the content of the cache to an io.Writer.
+// Entries returns the entries of the cache, in the order they were
+// added.
+func (c *Cache) Entries() []Entry {
+	return append([]Entry(nil), c.entries...)
+}
+
+// Filter replaces every entry of the cache by the entry returned by f,
+// or removes it if f returns false.  The ACLs and transforms are not
+// applied again.
+func (c *Cache) Filter(f func(e Entry) (Entry, bool)) {
+	entries := c.entries[:0]
+	for _, e := range c.entries {
+		if e, ok := f(e); ok {
+			entries = append(entries, e)
+		}
+	}
+	for i := len(entries); i < len(c.entries); i++ {
+		c.entries[i] = nil
+	}
+	c.entries = entries
+	c.order = nil
+	c.offsets = nil
+}
+
+// WriteTo writes the content of the cache to an io.Writer.  The offset
+// of every entry is recorded so that the indexes written afterwards do
+// not have to render the entries again.
 func (c *Cache) WriteTo(w io.Writer) (int64, error) {
+	entries := c.sorted()
+	offsets := make([]int64, len(entries))
 	total := int64(0)
-	for _, e := range c.entries {
+	for i, e := range entries {
+		offsets[i] = total
 		n, err := e.WriteTo(w)
 		if err != nil {
+			c.offsets = nil
 			return total + n, err
 		}
 		total += n
 	}
+	c.offsets = offsets
 	return total, nil
 }
 
-// Index generates an index for the given cache on a particular
-// column.  This is required for caches beyond a libnss-cache defined
-// size in order for them to be read correctly.
-func (c *Cache) Index(col int) bytes.Buffer {
-	ordered := make([]string, len(c.entries))
-	offsets := make(map[string]string, len(c.entries))
-	offset := 0
-	keyLen, posLen := 0, 0
-	for i := range c.entries {
-		key := c.entries[i].Column(col)
-		if len(key) > keyLen {
-			keyLen = len(key)
-		}
-		posStr := fmt.Sprintf("%d", offset)
-		if len(posStr) > posLen {
-			posLen = len(posStr)
-		}
-		ordered[i] = key
-		offsets[key] = posStr
-		offset += len(c.entries[i].String())
-	}
-	maxLen := keyLen + posLen
-
-	// libnss-cache depends on the indexes being ordered in order
-	// to accelerate the system with a binary search.
-	sort.Strings(ordered)
-
-	var b bytes.Buffer
-	for _, key := range ordered {
-		b.WriteString(key)
-		b.WriteByte(0)
-		b.WriteString(offsets[key])
-		for i := 0; i < maxLen-len(key)-len(offsets[key]); i++ {
-			b.WriteByte(0)
-		}
-		b.WriteString("\n")
+// MarshalJSON encodes the entries of the cache as a JSON array, in the
+// order they are written, which is the format read back by the S3
+// source.
+func (c *Cache) MarshalJSON() ([]byte, error) {
+	if c.entries == nil {
+		return []byte("[]"), nil
 	}
-	return b
+	return json.Marshal(c.sorted())
 }
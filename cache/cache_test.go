@@ -45,6 +45,40 @@ func TestCache_Add(t *testing.T) {
 	assert.Equal(t, expected, b.String())
 }
 
+func TestCache_Entries(t *testing.T) {
+	c := NewCache()
+	assert.Empty(t, c.Entries())
+
+	foo := &GroupEntry{Name: "foo", GID: 1000}
+	bar := &GroupEntry{Name: "bar", GID: 1001}
+	c.Add(foo, bar)
+	entries := c.Entries()
+	assert.Equal(t, []Entry{foo, bar}, entries)
+
+	// The entries returned are a copy
+	entries[0] = bar
+	assert.Equal(t, []Entry{foo, bar}, c.Entries())
+}
+
+func TestCache_Filter(t *testing.T) {
+	c := NewCache(WithTransform(func(e Entry) Entry {
+		return &GroupEntry{Name: "x" + e.Column(0)}
+	}))
+	c.Add(&GroupEntry{Name: "foo"}, &GroupEntry{Name: "bar"}, &GroupEntry{Name: "baz"})
+
+	c.Filter(func(e Entry) (Entry, bool) {
+		if e.Column(0) == "xbar" {
+			return nil, false
+		}
+		return &GroupEntry{Name: e.Column(0), GID: 1000}, true
+	})
+
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "xfoo:x:1000:\nxbaz:x:1000:\n", b.String())
+}
+
 func TestWithACL(t *testing.T) {
 	c := NewCache(WithACL(func(e Entry) bool {
 		pe, ok := e.(*PasswdEntry)
@@ -135,3 +169,20 @@ func TestCacheIndex(t *testing.T) {
 	expected := []byte{0x61, 0x64, 0x6d, 0x69, 0x6e, 0x0, 0x34, 0x33, 0xa, 0x62, 0x61, 0x72, 0x0, 0x38, 0x39, 0x0, 0x0, 0xa, 0x66, 0x6f, 0x6f, 0x0, 0x30, 0x0, 0x0, 0x0, 0xa}
 	assert.Equal(t, expected, idx.Bytes())
 }
+
+func TestCache_MarshalJSON(t *testing.T) {
+	c := NewCache()
+	b, err := c.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", string(b))
+
+	c.Add(&ShadowEntry{
+		Name:   "foo",
+		Passwd: "!!",
+		Min:    Int32(90),
+	})
+	b, err = c.MarshalJSON()
+	assert.Nil(t, err)
+	expected := `[{"name":"foo","passwd":"!!","lstchg":"","min":"90","max":"","warn":"","inact":"","expire":"","flag":""}]`
+	assert.Equal(t, expected, string(b))
+}
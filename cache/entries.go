@@ -3,6 +3,7 @@ package cache
 import (
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 )
 
@@ -70,6 +71,34 @@ func (e *PasswdEntry) WriteTo(w io.Writer) (int64, error) {
 	return toInt64(fmt.Fprintf(w, e.format(), e.args()...))
 }
 
+// Parse fills the entry from a line in the format of the /etc/passwd
+// file.
+func (e *PasswdEntry) Parse(line string) error {
+	fields, err := splitLine(line, 7)
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid uid: %w", err)
+	}
+	gid, err := strconv.ParseUint(fields[3], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid: %w", err)
+	}
+
+	*e = PasswdEntry{
+		Name:   fields[0],
+		Passwd: fields[1],
+		UID:    uint32(uid),
+		GID:    uint32(gid),
+		GECOS:  fields[4],
+		Dir:    fields[5],
+		Shell:  fields[6],
+	}
+	return nil
+}
+
 // ShadowEntry describes an entry of the /etc/shadow file
 // https://sourceware.org/git/?p=glibc.git;a=blob;f=shadow/shadow.h;hb=HEAD#l39
 // https://fossies.org/dox/glibc-2.30/structspwd.htmls
@@ -127,6 +156,47 @@ func (e *ShadowEntry) WriteTo(w io.Writer) (int64, error) {
 	return toInt64(fmt.Fprintf(w, e.format(), e.args()...))
 }
 
+// Parse fills the entry from a line in the format of the /etc/shadow
+// file.  Empty numeric fields are left unset.
+func (e *ShadowEntry) Parse(line string) error {
+	fields, err := splitLine(line, 9)
+	if err != nil {
+		return err
+	}
+
+	entry := ShadowEntry{
+		Name:   fields[0],
+		Passwd: fields[1],
+	}
+	for i, n := range []*nullInt32{
+		&entry.Lstchg,
+		&entry.Min,
+		&entry.Max,
+		&entry.Warn,
+		&entry.Inact,
+		&entry.Expire,
+	} {
+		if fields[i+2] == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(fields[i+2], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid field %d: %w", i+3, err)
+		}
+		*n = Int32(int32(v))
+	}
+	if fields[8] != "" {
+		v, err := strconv.ParseUint(fields[8], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid field 9: %w", err)
+		}
+		entry.Flag = UInt32(uint32(v))
+	}
+
+	*e = entry
+	return nil
+}
+
 // GroupEntry describes an entry of the /etc/group file
 // https://sourceware.org/git/?p=glibc.git;a=blob;f=grp/grp.h;hb=HEAD#l41
 // https://fossies.org/dox/glibc-2.30/structgroup.html
@@ -176,6 +246,45 @@ func (e *GroupEntry) WriteTo(w io.Writer) (int64, error) {
 	return toInt64(fmt.Fprintf(w, e.format(), e.args()...))
 }
 
+// Parse fills the entry from a line in the format of the /etc/group
+// file.
+func (e *GroupEntry) Parse(line string) error {
+	fields, err := splitLine(line, 4)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid gid: %w", err)
+	}
+
+	var mem []string
+	for _, m := range strings.Split(fields[3], ",") {
+		if m != "" {
+			mem = append(mem, m)
+		}
+	}
+
+	*e = GroupEntry{
+		Name:   fields[0],
+		Passwd: fields[1],
+		GID:    uint32(gid),
+		Mem:    mem,
+	}
+	return nil
+}
+
+// splitLine splits a line of a colon-separated file into exactly n
+// fields.
+func splitLine(line string, n int) ([]string, error) {
+	line = strings.TrimRight(line, "\r\n")
+	fields := strings.Split(line, ":")
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d fields, got %d", n, len(fields))
+	}
+	return fields, nil
+}
+
 func toInt64(i int, e error) (int64, error) {
 	return int64(i), e
 }
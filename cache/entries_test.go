@@ -51,6 +51,25 @@ func TestPasswdEntry_Column(t *testing.T) {
 	assert.Equal(t, "", e.Column(1))
 }
 
+func TestPasswdEntry_Parse(t *testing.T) {
+	var e PasswdEntry
+	assert.Nil(t, e.Parse("foo:x:1000:1000:Mr Foo:/home/foo:/usr/bin/bash\n"))
+	assert.Equal(t, PasswdEntry{
+		Name:   "foo",
+		Passwd: "x",
+		UID:    1000,
+		GID:    1000,
+		GECOS:  "Mr Foo",
+		Dir:    "/home/foo",
+		Shell:  "/usr/bin/bash",
+	}, e)
+	assert.Equal(t, "foo:x:1000:1000:Mr Foo:/home/foo:/usr/bin/bash\n", e.String())
+
+	assert.NotNil(t, e.Parse("foo:x:1000:1000:Mr Foo:/home/foo"))
+	assert.NotNil(t, e.Parse("foo:x:-1:1000:Mr Foo:/home/foo:/usr/bin/bash"))
+	assert.NotNil(t, e.Parse("foo:x:1000:bar:Mr Foo:/home/foo:/usr/bin/bash"))
+}
+
 func TestShadowEntry_String(t *testing.T) {
 	e := ShadowEntry{
 		Name: "foo",
@@ -79,6 +98,27 @@ func TestShadowEntry_Column(t *testing.T) {
 	assert.Equal(t, "", e.Column(1))
 }
 
+func TestShadowEntry_Parse(t *testing.T) {
+	var e ShadowEntry
+	assert.Nil(t, e.Parse("foo:!!:17321:0:99999:7:::"))
+	assert.Equal(t, ShadowEntry{
+		Name:   "foo",
+		Passwd: "!!",
+		Lstchg: Int32(17321),
+		Min:    Int32(0),
+		Max:    Int32(99999),
+		Warn:   Int32(7),
+	}, e)
+	assert.Equal(t, "foo:!!:17321:0:99999:7:::\n", e.String())
+
+	assert.Nil(t, e.Parse("bar:*::::::-1:1"))
+	assert.Equal(t, "bar:*::::::-1:1\n", e.String())
+
+	assert.NotNil(t, e.Parse("foo:!!:17321"))
+	assert.NotNil(t, e.Parse("foo:!!:abc::::::"))
+	assert.NotNil(t, e.Parse("foo:!!::::::::-1"))
+}
+
 func TestGroupEntry_String(t *testing.T) {
 	e := GroupEntry{
 		Name: "foo",
@@ -109,6 +149,23 @@ func TestGroupEntry_Column(t *testing.T) {
 	assert.Equal(t, "", e.Column(1))
 }
 
+func TestGroupEntry_Parse(t *testing.T) {
+	var e GroupEntry
+	assert.Nil(t, e.Parse("foo:x:1000:"))
+	assert.Equal(t, GroupEntry{
+		Name:   "foo",
+		Passwd: "x",
+		GID:    1000,
+	}, e)
+
+	assert.Nil(t, e.Parse("wheel:*:10:foo,bar"))
+	assert.Equal(t, []string{"foo", "bar"}, e.Mem)
+	assert.Equal(t, "wheel:*:10:foo,bar\n", e.String())
+
+	assert.NotNil(t, e.Parse("foo:x:1000"))
+	assert.NotNil(t, e.Parse("foo:x:bar:"))
+}
+
 func writerToError(i int64, e error) error {
 	return e
 }
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// indexKey is a key of an index with the offset of its entry.
+type indexKey struct {
+	key    string
+	offset int64
+}
+
+// Index generates an index for the given cache on a particular
+// column.  This is required for caches beyond a libnss-cache defined
+// size in order for them to be read correctly.  The whole index is
+// held in memory.  The entries whose key cannot be indexed are left
+// out of the index, only WriteIndex and IndexWriter report them.
+//
+// Deprecated: Use WriteIndex or IndexWriter, which stream the index
+// and return the error of a key which cannot be indexed.
+func (c *Cache) Index(col int) bytes.Buffer {
+	var b bytes.Buffer
+	c.writeIndex(&b, col, true)
+	return b
+}
+
+// IndexWriter returns an io.WriterTo streaming the index of the cache
+// on a particular column, as WriteIndex does.
+func (c *Cache) IndexWriter(col int) io.WriterTo {
+	return indexWriter{c: c, col: col}
+}
+
+// indexWriter streams the index of a cache on a column.
+type indexWriter struct {
+	c   *Cache
+	col int
+}
+
+// WriteTo writes the index to an io.Writer.
+func (w indexWriter) WriteTo(out io.Writer) (int64, error) {
+	return w.c.WriteIndex(out, w.col)
+}
+
+// WriteIndex writes the index of the cache on a particular column to
+// an io.Writer.  The offsets recorded by the last WriteTo are used if
+// the cache has not changed since, otherwise the length of every entry
+// is counted without keeping it.  Only the keys and their offsets are
+// held in memory while the index is sorted and written.
+//
+// Every entry has its own row, so a key shared by several entries,
+// such as a UID, has one row per entry in the order of the cache file
+// and a lookup may return any of them.  libnss-cache reads rows of a
+// fixed number of bytes and compares keys with strcmp, so the rows
+// are padded to the longest key in bytes and sorted in byte order,
+// whatever the encoding of the names.  A key containing a NUL byte or
+// a newline would break the rows and is an error.
+func (c *Cache) WriteIndex(w io.Writer, col int) (int64, error) {
+	return c.writeIndex(w, col, false)
+}
+
+// writeIndex writes the index of the cache on a particular column,
+// leaving out the keys which cannot be indexed if skipInvalid is true.
+func (c *Cache) writeIndex(w io.Writer, col int, skipInvalid bool) (int64, error) {
+	entries := c.sorted()
+	offsets := c.entryOffsets(entries)
+
+	keys := make([]indexKey, 0, len(entries))
+	keyLen, posLen := 0, 0
+	for i, e := range entries {
+		key := e.Column(col)
+		if strings.ContainsAny(key, "\x00\n") {
+			if skipInvalid {
+				continue
+			}
+			return 0, fmt.Errorf("invalid key %q in the index of column %d", key, col)
+		}
+		if len(key) > keyLen {
+			keyLen = len(key)
+		}
+		if l := digits(offsets[i]); l > posLen {
+			posLen = l
+		}
+		keys = append(keys, indexKey{key: key, offset: offsets[i]})
+	}
+	maxLen := keyLen + posLen
+
+	// libnss-cache depends on the indexes being ordered in order
+	// to accelerate the system with a binary search.
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].key < keys[j].key
+	})
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	line := make([]byte, 0, maxLen+2)
+	for _, k := range keys {
+		line = append(line[:0], k.key...)
+		line = append(line, 0)
+		line = strconv.AppendInt(line, k.offset, 10)
+		for len(line) < maxLen+1 {
+			line = append(line, 0)
+		}
+		line = append(line, '\n')
+		if _, err := bw.Write(line); err != nil {
+			return cw.n, err
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// entryOffsets returns the offsets of the given entries in the cache
+// file, which are the ones recorded by the last WriteTo if the cache
+// has not changed since.
+func (c *Cache) entryOffsets(entries []Entry) []int64 {
+	if c.offsets != nil && len(c.offsets) == len(entries) {
+		return c.offsets
+	}
+	offsets := make([]int64, len(entries))
+	cw := &countingWriter{w: io.Discard}
+	for i, e := range entries {
+		offsets[i] = cw.n
+		e.WriteTo(cw)
+	}
+	c.offsets = offsets
+	return offsets
+}
+
+// digits returns the number of decimal digits of a non-negative
+// number.
+func digits(n int64) int {
+	l := 1
+	for ; n >= 10; n /= 10 {
+		l++
+	}
+	return l
+}
+
+// countingWriter counts the bytes written to an io.Writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes p to the underlying io.Writer.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,217 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func indexTestCache() *Cache {
+	c := NewCache()
+	c.Add(&PasswdEntry{Name: "foo", Passwd: "x", UID: 1000, GID: 1000, Dir: "/home/foo", Shell: "/bin/bash"},
+		&PasswdEntry{Name: "admin", Passwd: "x", UID: 1002, GID: 1000, Dir: "/home/admin", Shell: "/bin/bash"},
+		&PasswdEntry{Name: "bar", Passwd: "x", UID: 1001, GID: 1000, Dir: "/home/bar", Shell: "/bin/bash"})
+	return c
+}
+
+func TestCache_WriteIndex(t *testing.T) {
+	c := indexTestCache()
+	expected := c.Index(2)
+
+	var data, idx bytes.Buffer
+	_, err := c.WriteTo(&data)
+	assert.Nil(t, err)
+	assert.Len(t, c.offsets, 3)
+	n, err := c.WriteIndex(&idx, 2)
+	assert.Nil(t, err)
+	assert.EqualValues(t, idx.Len(), n)
+	assert.Equal(t, expected.String(), idx.String())
+	assert.Equal(t, "1000\x000\x00\n1001\x0078\n1002\x0037\n", idx.String())
+
+	c.Add(&PasswdEntry{Name: "baz", Passwd: "x", UID: 999, GID: 1000, Dir: "/home/baz", Shell: "/bin/sh"})
+	assert.Nil(t, c.offsets)
+	idx.Reset()
+	_, err = c.IndexWriter(0).WriteTo(&idx)
+	assert.Nil(t, err)
+	assert.Equal(t, "admin\x0037\x00\nbar\x0078\x00\x00\x00\nbaz\x00115\x00\x00\nfoo\x000\x00\x00\x00\x00\n", idx.String())
+}
+
+func TestCache_WriteIndex_Error(t *testing.T) {
+	c := indexTestCache()
+	_, err := c.WriteIndex(&errorWriter{}, 0)
+	assert.NotNil(t, err)
+}
+
+// nssLookup looks a key up the way libnss-cache does: every row of
+// the index has the length of the first one, the rows are searched
+// with bsearch comparing keys with strcmp, and the offset following
+// the key is read with atol.
+func nssLookup(data, index []byte, key string) (string, bool) {
+	size := bytes.IndexByte(index, '\n') + 1
+	if size == 0 {
+		return "", false
+	}
+	l, u := 0, len(index)/size
+	for l < u {
+		i := (l + u) / 2
+		row := index[i*size : (i+1)*size]
+		rowKey := row[:bytes.IndexByte(row, 0)]
+		switch cmp := bytes.Compare([]byte(key), rowKey); {
+		case cmp < 0:
+			u = i
+		case cmp > 0:
+			l = i + 1
+		default:
+			offset := 0
+			for _, b := range row[len(rowKey)+1:] {
+				if b < '0' || b > '9' {
+					break
+				}
+				offset = offset*10 + int(b-'0')
+			}
+			line := data[offset:]
+			return string(line[:bytes.IndexByte(line, '\n')]), true
+		}
+	}
+	return "", false
+}
+
+func TestCache_WriteIndex_Lookup(t *testing.T) {
+	c := NewCache()
+	names := []string{"zoë", "zed", "émile", "Zed", "日本", "e", "éa", "root", "toor"}
+	for i, name := range names {
+		uid := uint32(1000 + i%3)
+		if name == "root" || name == "toor" {
+			uid = 0
+		}
+		c.Add(&PasswdEntry{Name: name, Passwd: "x", UID: uid, GID: 1000, Dir: "/home/" + name, Shell: "/bin/sh"})
+	}
+	var data bytes.Buffer
+	_, err := c.WriteTo(&data)
+	assert.Nil(t, err)
+
+	for col, keys := range map[int][]string{0: names, 2: {"0", "1000", "1001", "1002"}} {
+		var idx bytes.Buffer
+		_, err := c.WriteIndex(&idx, col)
+		assert.Nil(t, err)
+
+		rows := strings.SplitAfter(idx.String(), "\n")
+		rows = rows[:len(rows)-1]
+		assert.Len(t, rows, len(names))
+		for i, row := range rows {
+			assert.Len(t, row, len(rows[0]))
+			if i > 0 {
+				prev := rows[i-1][:strings.IndexByte(rows[i-1], 0)]
+				assert.LessOrEqual(t, prev, row[:strings.IndexByte(row, 0)])
+			}
+		}
+
+		for _, key := range keys {
+			line, ok := nssLookup(data.Bytes(), idx.Bytes(), key)
+			assert.True(t, ok, key)
+			assert.Equal(t, key, strings.Split(line, ":")[col])
+
+			// Every entry sharing the key has its own row.
+			found := map[string]bool{}
+			for _, row := range rows {
+				if row[:strings.IndexByte(row, 0)] == key {
+					found[row] = true
+				}
+			}
+			expected := 0
+			for _, e := range c.Entries() {
+				if e.Column(col) == key {
+					expected++
+				}
+			}
+			assert.Len(t, found, expected, key)
+		}
+		_, ok := nssLookup(data.Bytes(), idx.Bytes(), "missing")
+		assert.False(t, ok)
+	}
+}
+
+func TestCache_WriteIndex_InvalidKey(t *testing.T) {
+	c := NewCache()
+	c.Add(&PasswdEntry{Name: "foo\x00bar", Passwd: "x"})
+	var idx bytes.Buffer
+	_, err := c.WriteIndex(&idx, 0)
+	assert.EqualError(t, err, `invalid key "foo\x00bar" in the index of column 0`)
+	assert.Zero(t, idx.Len())
+
+	// The deprecated Index leaves the entry out
+	c.Add(&PasswdEntry{Name: "bar", Passwd: "x"})
+	idx = c.Index(0)
+	assert.Equal(t, "bar\x0017\n", idx.String())
+}
+
+func benchmarkCache(n int) *Cache {
+	c := NewCache()
+	for i := 0; i < n; i++ {
+		c.Add(&GroupEntry{
+			Name:   fmt.Sprintf("group%d", i),
+			Passwd: "x",
+			GID:    uint32(10000 + i),
+			Mem:    []string{"foo", "bar", fmt.Sprintf("user%d", i)},
+		})
+	}
+	return c
+}
+
+var benchmarkSizes = []int{1000, 100000, 500000}
+
+func BenchmarkCache_WriteTo(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		c := benchmarkCache(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.WriteTo(io.Discard)
+			}
+		})
+	}
+}
+
+func BenchmarkCache_Index(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		c := benchmarkCache(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.offsets = nil
+				idx := c.Index(0)
+				idx.WriteTo(io.Discard)
+			}
+		})
+	}
+}
+
+func BenchmarkCache_WriteIndex(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		c := benchmarkCache(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.offsets = nil
+				c.WriteIndex(io.Discard, 0)
+			}
+		})
+	}
+}
+
+func BenchmarkCache_WriteIndex_AfterWriteTo(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		c := benchmarkCache(n)
+		c.WriteTo(io.Discard)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c.WriteIndex(io.Discard, 0)
+			}
+		})
+	}
+}
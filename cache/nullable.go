@@ -6,6 +6,19 @@ import (
 	"strconv"
 )
 
+// unquote returns the content of a JSON string, or the digits of a
+// JSON number, so that the nullable fields may be given either way.
+func unquote(b []byte) (string, error) {
+	if len(b) > 0 && b[0] != '"' {
+		var n json.Number
+		err := json.Unmarshal(b, &n)
+		return n.String(), err
+	}
+	var s string
+	err := json.Unmarshal(b, &s)
+	return s, err
+}
+
 type nullInt32 struct {
 	val   int32
 	valid bool
@@ -28,8 +41,8 @@ func Int32(v int32) nullInt32 {
 }
 
 func (n *nullInt32) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
+	s, err := unquote(b)
+	if err != nil {
 		return err
 	}
 	n.val = 0
@@ -72,8 +85,8 @@ func UInt32(v uint32) nullUInt32 {
 }
 
 func (n *nullUInt32) UnmarshalJSON(b []byte) error {
-	var s string
-	if err := json.Unmarshal(b, &s); err != nil {
+	s, err := unquote(b)
+	if err != nil {
 		return err
 	}
 	n.val = 0
@@ -36,8 +36,14 @@ func TestNullInt32_UnmarshalJSON(t *testing.T) {
 	assert.True(t, n.valid)
 	assert.EqualValues(t, 200, n.val)
 
+	assert.Nil(t, n.UnmarshalJSON([]byte(`19000`)))
+	assert.True(t, n.valid)
+	assert.EqualValues(t, 19000, n.val)
+
 	assert.NotNil(t, n.UnmarshalJSON([]byte(`['([)]'/`)))
 	assert.NotNil(t, n.UnmarshalJSON([]byte(`"foo"`)))
+	assert.NotNil(t, n.UnmarshalJSON([]byte(`1.5`)))
+	assert.NotNil(t, n.UnmarshalJSON([]byte(`true`)))
 }
 
 func TestNullUInt32_String(t *testing.T) {
@@ -70,6 +76,12 @@ func TestNullUInt32_UnmarshalJSON(t *testing.T) {
 	assert.True(t, n.valid)
 	assert.EqualValues(t, 200, n.val)
 
+	assert.Nil(t, n.UnmarshalJSON([]byte(`19000`)))
+	assert.True(t, n.valid)
+	assert.EqualValues(t, 19000, n.val)
+
 	assert.NotNil(t, n.UnmarshalJSON([]byte(`['([)]'/`)))
 	assert.NotNil(t, n.UnmarshalJSON([]byte(`"foo"`)))
+	assert.NotNil(t, n.UnmarshalJSON([]byte(`1.5`)))
+	assert.NotNil(t, n.UnmarshalJSON([]byte(`true`)))
 }
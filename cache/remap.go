@@ -0,0 +1,147 @@
+package cache
+
+import "fmt"
+
+// DefaultMinID is the first ID outside of the system range, which the
+// IDs cannot be remapped into.
+const DefaultMinID = 1000
+
+// IDMapping maps the Size IDs starting at From onto the IDs starting at
+// To, e.g. {From: 1000, To: 101000, Size: 1000} offsets the IDs from
+// 1000 to 1999 by 100000.
+type IDMapping struct {
+	From uint32
+	To   uint32
+	Size uint32
+}
+
+func (m IDMapping) contains(id uint32) bool {
+	return id >= m.From && uint64(id) < uint64(m.From)+uint64(m.Size)
+}
+
+// IDMap remaps the UIDs and GIDs of the entries.  The IDs outside of
+// the mappings are left untouched.
+type IDMap struct {
+	UIDs  []IDMapping
+	GIDs  []IDMapping
+	MinID uint32 // First ID the IDs can be remapped into, DefaultMinID if 0
+}
+
+// Offset returns the mapping adding offset to the size IDs starting at
+// from.
+func Offset(from, size, offset uint32) IDMapping {
+	return IDMapping{From: from, To: from + offset, Size: size}
+}
+
+// Validate checks that the mappings neither overlap, overflow, nor land
+// in the system range.
+func (m IDMap) Validate() error {
+	minID := m.MinID
+	if minID == 0 {
+		minID = DefaultMinID
+	}
+	for _, ms := range []struct {
+		kind     string
+		mappings []IDMapping
+	}{{"uid", m.UIDs}, {"gid", m.GIDs}} {
+		for i, a := range ms.mappings {
+			if uint64(a.From)+uint64(a.Size) > 1<<32 || uint64(a.To)+uint64(a.Size) > 1<<32 {
+				return fmt.Errorf("%s mapping %d overflows", ms.kind, i+1)
+			}
+			if a.To < minID {
+				return fmt.Errorf("%s mapping %d lands in the system range below %d", ms.kind, i+1, minID)
+			}
+			for j, b := range ms.mappings[:i] {
+				if a.From < b.From+b.Size && b.From < a.From+a.Size {
+					return fmt.Errorf("%s mappings %d and %d overlap", ms.kind, j+1, i+1)
+				}
+				if a.To < b.To+b.Size && b.To < a.To+a.Size {
+					return fmt.Errorf("%s mappings %d and %d map onto the same IDs", ms.kind, j+1, i+1)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func remapID(mappings []IDMapping, id uint32) uint32 {
+	for _, m := range mappings {
+		if m.contains(id) {
+			return id - m.From + m.To
+		}
+	}
+	return id
+}
+
+// UID returns the remapped UID.
+func (m IDMap) UID(id uint32) uint32 {
+	return remapID(m.UIDs, id)
+}
+
+// GID returns the remapped GID.
+func (m IDMap) GID(id uint32) uint32 {
+	return remapID(m.GIDs, id)
+}
+
+// owners records the names of the entries owning the IDs.
+type owners map[uint32]string
+
+func (o owners) add(kind string, id uint32, name string) error {
+	if owner, ok := o[id]; ok && owner != name {
+		return fmt.Errorf("%s %d of %s collides with %s", kind, id, name, owner)
+	}
+	o[id] = name
+	return nil
+}
+
+// Remap remaps the UIDs and GIDs of the passwd entries and the GIDs of
+// the group entries of the cache.  The remapping is refused, and the
+// cache left untouched, if the mappings are not valid, or if a remapped
+// UID or GID is owned by another user or group, either in the cache or
+// in the existing caches provided.  The indexes, which are generated
+// from the entries, follow.
+func (c *Cache) Remap(m IDMap, existing ...*Cache) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
+	uids, gids := owners{}, owners{}
+	for _, other := range existing {
+		for _, e := range other.entries {
+			switch v := e.(type) {
+			case *PasswdEntry:
+				uids[v.UID] = v.Name
+			case *GroupEntry:
+				gids[v.GID] = v.Name
+			}
+		}
+	}
+
+	remapped := make([]Entry, len(c.entries))
+	for i, e := range c.entries {
+		switch v := e.(type) {
+		case *PasswdEntry:
+			cp := *v
+			cp.UID = m.UID(v.UID)
+			cp.GID = m.GID(v.GID)
+			if err := uids.add("uid", cp.UID, cp.Name); err != nil {
+				return err
+			}
+			remapped[i] = &cp
+		case *GroupEntry:
+			cp := *v
+			cp.GID = m.GID(v.GID)
+			if err := gids.add("gid", cp.GID, cp.Name); err != nil {
+				return err
+			}
+			remapped[i] = &cp
+		default:
+			remapped[i] = e
+		}
+	}
+
+	c.entries = remapped
+	c.order = nil
+	c.offsets = nil
+	return nil
+}
@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func remapCache() *Cache {
+	c := NewCache()
+	c.Add(
+		&PasswdEntry{Name: "foo", Passwd: "x", UID: 1000, GID: 1000},
+		&PasswdEntry{Name: "bar", Passwd: "x", UID: 1500, GID: 2000},
+		&PasswdEntry{Name: "root", Passwd: "x", UID: 0, GID: 0},
+		&ShadowEntry{Name: "foo", Passwd: "*"},
+		&GroupEntry{Name: "foo", GID: 1000, Mem: []string{"foo"}},
+		&GroupEntry{Name: "staff", GID: 2000},
+	)
+	return c
+}
+
+func TestCache_Remap(t *testing.T) {
+	c := remapCache()
+	foo := c.entries[0].(*PasswdEntry)
+
+	err := c.Remap(IDMap{
+		UIDs: []IDMapping{Offset(1000, 1000, 100000)},
+		GIDs: []IDMapping{{From: 1000, To: 50000, Size: 1}, {From: 2000, To: 60000, Size: 10}},
+	})
+	assert.Nil(t, err)
+
+	var b bytes.Buffer
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	expected := "foo:x:101000:50000:::\nbar:x:101500:60000:::\nroot:x:0:0:::\nfoo:*:::::::\nfoo:x:50000:foo\nstaff:x:60000:\n"
+	assert.Equal(t, expected, b.String())
+
+	idx := c.Index(2)
+	assert.Contains(t, idx.String(), "101000\x00")
+
+	// The entries added are left untouched
+	assert.EqualValues(t, 1000, foo.UID)
+}
+
+func TestCache_RemapCollisions(t *testing.T) {
+	c := remapCache()
+	err := c.Remap(IDMap{UIDs: []IDMapping{{From: 1500, To: 1000, Size: 1}}})
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "uid 1000 of bar collides with foo", err.Error())
+	}
+	assert.EqualValues(t, 1500, c.entries[1].(*PasswdEntry).UID)
+
+	existing := NewCache()
+	existing.Add(&PasswdEntry{Name: "baz", UID: 101000}, &GroupEntry{Name: "admins", GID: 60000})
+	err = c.Remap(IDMap{UIDs: []IDMapping{Offset(1000, 1000, 100000)}}, existing)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "uid 101000 of foo collides with baz", err.Error())
+	}
+	err = c.Remap(IDMap{GIDs: []IDMapping{Offset(2000, 1, 58000)}}, existing)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "gid 60000 of staff collides with admins", err.Error())
+	}
+
+	// The same user in the existing caches
+	existing = NewCache()
+	existing.Add(&PasswdEntry{Name: "foo", UID: 101000})
+	assert.Nil(t, c.Remap(IDMap{UIDs: []IDMapping{Offset(1000, 1000, 100000)}}, existing))
+}
+
+func TestIDMap_Validate(t *testing.T) {
+	for m, expected := range map[*IDMap]string{
+		{UIDs: []IDMapping{{From: 1000, To: 500, Size: 10}}}:                                    "uid mapping 1 lands in the system range below 1000",
+		{UIDs: []IDMapping{{From: 1000, To: 500, Size: 10}}, MinID: 500}:                        "",
+		{GIDs: []IDMapping{{From: 1000, To: 4294967000, Size: 1000}}}:                           "gid mapping 1 overflows",
+		{GIDs: []IDMapping{{From: 1000, To: 5000, Size: 100}, Offset(1050, 10, 10000)}}:         "gid mappings 1 and 2 overlap",
+		{UIDs: []IDMapping{{From: 1000, To: 5000, Size: 100}, {From: 2000, To: 5099, Size: 1}}}: "uid mappings 1 and 2 map onto the same IDs",
+		{UIDs: []IDMapping{Offset(1000, 100, 4000), Offset(2000, 100, 3100)}}:                   "",
+	} {
+		err := m.Validate()
+		if expected == "" {
+			assert.Nil(t, err)
+		} else if assert.NotNil(t, err) {
+			assert.Equal(t, expected, err.Error())
+		}
+	}
+}
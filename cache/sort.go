@@ -0,0 +1,66 @@
+package cache
+
+import "sort"
+
+// Less specifies a function which will return true if the entry a is
+// written before the entry b.
+type Less func(a, b Entry) bool
+
+// WithSort is a convenience function to obtain an Option function
+// writing the entries of the cache in the order of less, so that the
+// files only change when the entries do.  The entries are sorted when
+// the cache is written and indexed; the ties keep the insertion order.
+func WithSort(less Less) Option {
+	return func(c *Cache) { c.less = less }
+}
+
+// ByName sorts the entries by name.
+func ByName(a, b Entry) bool {
+	return a.Column(0) < b.Column(0)
+}
+
+// ByID sorts the passwd entries by UID and the group entries by GID,
+// then by name.  The entries without ID, such as the shadow entries,
+// are sorted by name.
+func ByID(a, b Entry) bool {
+	ida, oka := entryID(a)
+	idb, okb := entryID(b)
+	if oka && okb && ida != idb {
+		return ida < idb
+	}
+	return ByName(a, b)
+}
+
+// entryID returns the UID of a passwd entry or the GID of a group
+// entry.
+func entryID(e Entry) (uint32, bool) {
+	switch e := e.(type) {
+	case *PasswdEntry:
+		return e.UID, true
+	case *GroupEntry:
+		return e.GID, true
+	default:
+		return 0, false
+	}
+}
+
+// Sorted returns the entries of the cache in the order they are
+// written.
+func (c *Cache) Sorted() []Entry {
+	return append([]Entry(nil), c.sorted()...)
+}
+
+// sorted returns the entries in the order they are written.  They are
+// sorted once and kept until the entries change.
+func (c *Cache) sorted() []Entry {
+	if c.less == nil {
+		return c.entries
+	}
+	if c.order == nil {
+		c.order = append([]Entry(nil), c.entries...)
+		sort.SliceStable(c.order, func(i, j int) bool {
+			return c.less(c.order[i], c.order[j])
+		})
+	}
+	return c.order
+}
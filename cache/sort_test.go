@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sortCache(opts ...Option) *Cache {
+	c := NewCache(opts...)
+	c.Add(
+		&PasswdEntry{Name: "foo", Passwd: "x", UID: 1001, GID: 1000},
+		&PasswdEntry{Name: "bar", Passwd: "x", UID: 1000, GID: 1000},
+		&PasswdEntry{Name: "admin", Passwd: "x", UID: 999, GID: 1000},
+	)
+	return c
+}
+
+func TestWithSort(t *testing.T) {
+	for _, tc := range []struct {
+		less     Less
+		expected string
+		admin    string
+	}{
+		{nil, "foo:x:1001:1000:::\nbar:x:1000:1000:::\nadmin:x:999:1000:::\n", "38"},
+		{ByName, "admin:x:999:1000:::\nbar:x:1000:1000:::\nfoo:x:1001:1000:::\n", "0"},
+		{ByID, "admin:x:999:1000:::\nbar:x:1000:1000:::\nfoo:x:1001:1000:::\n", "0"},
+		{func(a, b Entry) bool { return a.Column(0) > b.Column(0) }, "foo:x:1001:1000:::\nbar:x:1000:1000:::\nadmin:x:999:1000:::\n", "38"},
+	} {
+		c := sortCache()
+		if tc.less != nil {
+			c = sortCache(WithSort(tc.less))
+		}
+		var b bytes.Buffer
+		_, err := c.WriteTo(&b)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, b.String())
+
+		// The offsets of the index follow the order of the entries
+		idx := c.Index(0)
+		assert.Regexp(t, "admin\x00"+tc.admin+"[\x00\n]", idx.String())
+	}
+
+	// With the same ID, by name
+	c := NewCache(WithSort(ByID))
+	c.Add(&GroupEntry{Name: "b", Passwd: "x", GID: 1}, &GroupEntry{Name: "a", Passwd: "x", GID: 1}, &GroupEntry{Name: "c", Passwd: "x", GID: 0})
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "c:x:0:\na:x:1:\nb:x:1:\n", b.String())
+
+	// The entries are kept in insertion order
+	assert.Equal(t, "b", c.Entries()[0].Column(0))
+	assert.Equal(t, "c", c.Sorted()[0].Column(0))
+
+	// Without ID, by name
+	c = NewCache(WithSort(ByID))
+	c.Add(&ShadowEntry{Name: "foo"}, &ShadowEntry{Name: "bar"})
+	b.Reset()
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar:!!:::::::\nfoo:!!:::::::\n", b.String())
+}
+
+func TestWithSort_Once(t *testing.T) {
+	calls := 0
+	c := sortCache(WithSort(func(a, b Entry) bool {
+		calls++
+		return ByName(a, b)
+	}))
+
+	// The entries are sorted once for the file and all its indexes
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	n := calls
+	assert.NotZero(t, n)
+	_, err = c.WriteIndex(&b, 0)
+	assert.Nil(t, err)
+	_, err = c.WriteIndex(&b, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, n, calls)
+
+	// and again once they change
+	c.Add(&PasswdEntry{Name: "baz", Passwd: "x", UID: 1002, GID: 1000})
+	b.Reset()
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Greater(t, calls, n)
+	assert.Equal(t, "admin:x:999:1000:::\nbar:x:1000:1000:::\nbaz:x:1002:1000:::\nfoo:x:1001:1000:::\n", b.String())
+}
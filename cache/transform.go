@@ -0,0 +1,92 @@
+package cache
+
+import "strings"
+
+// Transform specifies a function which will return the entry to add to
+// the cache in place of the provided entry.  Transforms must not
+// modify the provided entry, which may be shared with other caches;
+// the typed helpers work on a copy.
+type Transform func(e Entry) Entry
+
+// WithTransform is a convenience function to obtain an Option function
+// for the specified transform.  The ACLs and the transforms are applied
+// in the order of the options, so an ACL sees the entries transformed
+// by the transforms before it.
+func WithTransform(t Transform) Option {
+	return func(c *Cache) {
+		c.pipeline = append(c.pipeline, func(e Entry) (Entry, bool) {
+			return t(e), true
+		})
+	}
+}
+
+// TransformPasswd returns a Transform calling f with a copy of every
+// passwd entry.  The other entries are left untouched.
+func TransformPasswd(f func(e *PasswdEntry)) Transform {
+	return func(e Entry) Entry {
+		pe, ok := e.(*PasswdEntry)
+		if !ok {
+			return e
+		}
+		cp := *pe
+		f(&cp)
+		return &cp
+	}
+}
+
+// TransformShadow returns a Transform calling f with a copy of every
+// shadow entry.  The other entries are left untouched.
+func TransformShadow(f func(e *ShadowEntry)) Transform {
+	return func(e Entry) Entry {
+		se, ok := e.(*ShadowEntry)
+		if !ok {
+			return e
+		}
+		cp := *se
+		f(&cp)
+		return &cp
+	}
+}
+
+// TransformGroup returns a Transform calling f with a copy of every
+// group entry.  The other entries are left untouched.
+func TransformGroup(f func(e *GroupEntry)) Transform {
+	return func(e Entry) Entry {
+		ge, ok := e.(*GroupEntry)
+		if !ok {
+			return e
+		}
+		cp := *ge
+		cp.Mem = append([]string(nil), ge.Mem...)
+		f(&cp)
+		return &cp
+	}
+}
+
+// ReplaceShell returns a Transform replacing the `from` shell of the
+// passwd entries by the `to` shell, e.g. `/bin/zsh` by `/bin/bash` on
+// images without zsh.
+func ReplaceShell(from, to string) Transform {
+	return TransformPasswd(func(e *PasswdEntry) {
+		if e.Shell == from {
+			e.Shell = to
+		}
+	})
+}
+
+// HomeTemplate returns a Transform setting the home directory of the
+// passwd entries from the template, where `{name}` is replaced by the
+// name of the user, e.g. `/home/{name}`.
+func HomeTemplate(template string) Transform {
+	return TransformPasswd(func(e *PasswdEntry) {
+		e.Dir = strings.ReplaceAll(template, "{name}", e.Name)
+	})
+}
+
+// StripGECOS returns a Transform removing the GECOS field of the passwd
+// entries.
+func StripGECOS() Transform {
+	return TransformPasswd(func(e *PasswdEntry) {
+		e.GECOS = ""
+	})
+}
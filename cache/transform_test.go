@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTransform(t *testing.T) {
+	foo := &PasswdEntry{Name: "foo", Passwd: "x", UID: 1000, GID: 1000, GECOS: "Mr Foo", Dir: "/srv/foo", Shell: "/bin/zsh"}
+	svc := &PasswdEntry{Name: "svc", Passwd: "x", UID: 999, GID: 999, GECOS: "Service", Dir: "/var/lib/svc", Shell: "/bin/zsh"}
+
+	c := NewCache(
+		WithTransform(ReplaceShell("/bin/zsh", "/bin/bash")),
+		WithTransform(HomeTemplate("/home/{name}")),
+		WithTransform(StripGECOS()),
+		WithTransform(TransformPasswd(func(e *PasswdEntry) {
+			if e.UID < 1000 {
+				e.Shell = "/sbin/nologin"
+			}
+		})),
+		WithTransform(TransformShadow(func(e *ShadowEntry) {
+			e.Passwd = "*"
+		})),
+	)
+	c.Add(foo, svc, &ShadowEntry{Name: "foo", Passwd: "$6$foo"}, &GroupEntry{Name: "foo", GID: 1000})
+
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	expected := "foo:x:1000:1000::/home/foo:/bin/bash\nsvc:x:999:999::/home/svc:/sbin/nologin\nfoo:*:::::::\nfoo:x:1000:\n"
+	assert.Equal(t, expected, b.String())
+
+	// The entries added are left untouched
+	assert.Equal(t, "/bin/zsh", foo.Shell)
+	assert.Equal(t, "Mr Foo", foo.GECOS)
+	assert.Equal(t, "/var/lib/svc", svc.Dir)
+}
+
+func TestWithTransform_Order(t *testing.T) {
+	c := NewCache(
+		WithACL(func(e Entry) bool { return e.Column(0) != "bar" }),
+		WithTransform(TransformGroup(func(e *GroupEntry) {
+			e.Name = "bar"
+			e.Mem = append(e.Mem, "baz")
+		})),
+		WithACL(func(e Entry) bool { return len(e.(*GroupEntry).Mem) > 1 }),
+	)
+
+	foo := &GroupEntry{Name: "foo", GID: 1000, Mem: []string{"foo"}}
+	c.Add(foo, &GroupEntry{Name: "bar", GID: 1001, Mem: []string{"foo"}}, &GroupEntry{Name: "qux", GID: 1002})
+
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "bar:x:1000:foo,baz\n", b.String())
+	assert.Equal(t, []string{"foo"}, foo.Mem)
+}
@@ -0,0 +1,114 @@
+// command explaining why users are allowed or denied by ACL rules on
+// this host, using the data of the source filling the caches before
+// any ACL is applied
+//
+// The Vault, S3, LDAP and SQL sources cannot be queried directly: the
+// program filling the caches from them must wrap them with
+// source.NewFallback, or source.NewCached with CacheDir, whose
+// snapshot directory is then given with -snapshots.
+//
+//	nsscache-explain -rules /etc/nsscache/acl.yaml -snapshots DIR USER...
+//	nsscache-explain -rules /etc/nsscache/acl.yaml -url TEMPLATE USER...
+//	nsscache-explain -rules /etc/nsscache/acl.yaml -git REPO USER...
+//	nsscache-explain -rules /etc/nsscache/acl.yaml -passwd FILE -group FILE USER...
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/MiLk/nsscache-go/acl"
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source"
+	"github.com/MiLk/nsscache-go/source/files"
+	"github.com/MiLk/nsscache-go/source/git"
+	"github.com/MiLk/nsscache-go/source/http"
+)
+
+const usage = `usage: nsscache-explain -rules FILE (-snapshots DIR | -url TEMPLATE | -git REPO | -passwd FILE -group FILE) USER...
+
+The users of the Vault, S3, LDAP and SQL sources are explained from the
+snapshots of the source.NewFallback, or source.NewCached with CacheDir,
+wrapping them in the program filling the caches, given with -snapshots.`
+
+func main() {
+	if err := mainE(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func mainE(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("nsscache-explain", flag.ContinueOnError)
+	rules := fs.String("rules", "", "file of the ACL rules, in JSON or YAML")
+	snapshots := fs.String("snapshots", "", "directory of the snapshots kept by a fallback or cached source, required for the Vault, S3, LDAP and SQL sources")
+	url := fs.String("url", "", "template of the URLs of the maps of an HTTP source")
+	repo := fs.String("git", "", "local git repository of the maps")
+	passwd := fs.String("passwd", "", "passwd file of the users")
+	group := fs.String("group", "", "group file of the users")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *rules == "" || fs.NArg() == 0 {
+		return errors.New(usage)
+	}
+
+	p, err := acl.Load(*rules)
+	if err != nil {
+		return err
+	}
+	src, err := newSource(*snapshots, *url, *repo, *passwd, *group)
+	if err != nil {
+		return err
+	}
+
+	// The groups of the policy are read from the source along with the
+	// users, none of them filtered.
+	users := cache.NewCache()
+	if err := p.Source(src).FillPasswdCache(users); err != nil {
+		return err
+	}
+	byName := map[string]cache.Entry{}
+	for _, e := range users.Entries() {
+		byName[e.Column(0)] = e
+	}
+
+	for _, name := range fs.Args() {
+		e, ok := byName[name]
+		if !ok {
+			fmt.Fprintf(w, "%s: unknown user\n", name)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, p.Explain(e))
+	}
+	return nil
+}
+
+// newSource returns the source of the only kind given.
+func newSource(snapshots, url, repo, passwd, group string) (source.Source, error) {
+	kinds := 0
+	for _, v := range []string{snapshots, url, repo, passwd + group} {
+		if v != "" {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return nil, errors.New(usage)
+	}
+
+	switch {
+	case snapshots != "":
+		return source.NewSnapshots(snapshots)
+	case url != "":
+		return http.NewSource(http.URL(url))
+	case repo != "":
+		return git.NewSource(repo)
+	case passwd == "" || group == "":
+		return nil, errors.New(usage)
+	default:
+		return files.NewSource(files.Passwd(passwd), files.Group(group))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	gohttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMainE(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"acl.yaml":              "rules:\n  - {action: allow, group: sre}\n  - {action: deny, name: 'svc-*'}\n",
+		"passwd":                "foo:x:1000:1000::/home/foo:/bin/bash\nbar:x:1001:1001::/home/bar:/bin/bash\nsvc-web:x:999:999::/:/sbin/nologin\n",
+		"group":                 "sre:x:2000:foo\n",
+		"snapshots/passwd.json": `[{"name": "foo", "passwd": "x", "uid": 1000, "gid": 1000}, {"name": "bar", "passwd": "x", "uid": 1001, "gid": 1001}, {"name": "svc-web", "passwd": "x", "uid": 999, "gid": 999}]`,
+		"snapshots/group.json":  `[{"name": "sre", "passwd": "x", "gid": 2000, "mem": ["foo"]}]`,
+	}
+	assert.Nil(t, os.Mkdir(filepath.Join(dir, "snapshots"), 0755))
+	for name, content := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	ts := httptest.NewServer(gohttp.FileServer(gohttp.Dir(filepath.Join(dir, "snapshots"))))
+	defer ts.Close()
+
+	rules := []string{"-rules", filepath.Join(dir, "acl.yaml")}
+	users := []string{"foo", "bar", "svc-web", "baz"}
+	expected := "foo: allowed by rule 1 (allow group=sre)\nbar: denied by default\nsvc-web: denied by rule 2 (deny name=svc-*)\nbaz: unknown user\n"
+	for _, src := range [][]string{
+		{"-passwd", filepath.Join(dir, "passwd"), "-group", filepath.Join(dir, "group")},
+		{"-snapshots", filepath.Join(dir, "snapshots")},
+		{"-url", ts.URL + "/{map}.json"},
+	} {
+		var b bytes.Buffer
+		args := append(append(append([]string{}, rules...), src...), users...)
+		assert.Nil(t, mainE(args, &b), src[0])
+		assert.Equal(t, expected, b.String(), src[0])
+	}
+
+	var b bytes.Buffer
+	err = mainE([]string{"foo"}, &b)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "snapshots of the source.NewFallback")
+	}
+	assert.NotNil(t, mainE([]string{"-rules", filepath.Join(dir, "missing.yaml"), "-snapshots", dir, "foo"}, &b))
+	assert.NotNil(t, mainE(append(rules, "foo"), &b))
+	assert.NotNil(t, mainE(append(rules, "-passwd", filepath.Join(dir, "passwd"), "foo"), &b))
+	assert.NotNil(t, mainE(append(rules, "-snapshots", dir, "-git", dir, "foo"), &b))
+	assert.NotNil(t, mainE(append(rules, "-snapshots", filepath.Join(dir, "missing"), "foo"), &b))
+}
@@ -0,0 +1,78 @@
+package nsscache
+
+import (
+	"fmt"
+
+	"github.com/MiLk/nsscache-go/cache"
+)
+
+// KeepGroupMembers removes from the caches the users who are not
+// members of any of the named groups, either by their primary GID or by
+// being listed in their members.  The shadow entries and the members of
+// the groups are pruned to match; the groups themselves are kept.  It
+// is meant to be called after FillCaches.
+func (cm *CacheMap) KeepGroupMembers(groups ...string) error {
+	passwd, ok := (*cm)["passwd"]
+	if !ok {
+		return fmt.Errorf("no passwd cache")
+	}
+	group, ok := (*cm)["group"]
+	if !ok {
+		return fmt.Errorf("no group cache")
+	}
+
+	allowed := map[string]bool{}
+	for _, g := range groups {
+		allowed[g] = true
+	}
+	gids := map[uint32]bool{}
+	members := map[string]bool{}
+	for _, e := range group.Entries() {
+		g, ok := e.(*cache.GroupEntry)
+		if !ok || !allowed[g.Name] {
+			continue
+		}
+		gids[g.GID] = true
+		for _, m := range g.Mem {
+			members[m] = true
+		}
+	}
+
+	users := map[string]bool{}
+	passwd.Filter(func(e cache.Entry) (cache.Entry, bool) {
+		u, ok := e.(*cache.PasswdEntry)
+		if !ok || (!gids[u.GID] && !members[u.Name]) {
+			return nil, false
+		}
+		users[u.Name] = true
+		return e, true
+	})
+
+	if shadow, ok := (*cm)["shadow"]; ok {
+		shadow.Filter(func(e cache.Entry) (cache.Entry, bool) {
+			return e, users[e.Column(0)]
+		})
+	}
+
+	group.Filter(func(e cache.Entry) (cache.Entry, bool) {
+		g, ok := e.(*cache.GroupEntry)
+		if !ok {
+			return e, true
+		}
+		var mem []string
+		for _, m := range g.Mem {
+			if users[m] {
+				mem = append(mem, m)
+			}
+		}
+		if len(mem) == len(g.Mem) {
+			return e, true
+		}
+		// The entries may be shared with other caches
+		cp := *g
+		cp.Mem = mem
+		return &cp, true
+	})
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package nsscache
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/MiLk/nsscache-go/cache"
+)
+
+type membersSource struct {
+	testSource
+}
+
+func (s *membersSource) FillPasswdCache(c *cache.Cache) error {
+	if err := s.testSource.FillPasswdCache(c); err != nil {
+		return err
+	}
+	c.Add(&cache.PasswdEntry{
+		Name:   "ops",
+		Passwd: "x",
+		UID:    1003,
+		GID:    1003,
+		Dir:    "/home/ops",
+		Shell:  "/bin/bash",
+	})
+	return nil
+}
+
+func (s *membersSource) FillShadowCache(c *cache.Cache) error {
+	c.Add(
+		&cache.ShadowEntry{Name: "foo", Passwd: "*"},
+		&cache.ShadowEntry{Name: "ops", Passwd: "*"},
+	)
+	return nil
+}
+
+func (s *membersSource) FillGroupCache(c *cache.Cache) error {
+	c.Add(
+		&cache.GroupEntry{Name: "foo", Passwd: "*", GID: 1000},
+		&cache.GroupEntry{Name: "sre", Passwd: "*", GID: 2000, Mem: []string{"ops", "admin"}},
+		&cache.GroupEntry{Name: "wheel", Passwd: "*", GID: 10, Mem: []string{"admin", "foo", "ops"}},
+	)
+	return nil
+}
+
+func writeCache(t *testing.T, c *cache.Cache) string {
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	return b.String()
+}
+
+func TestCacheMap_KeepGroupMembers(t *testing.T) {
+	cm := NewCaches()
+	assert.Nil(t, cm.FillCaches(&membersSource{}))
+	assert.Nil(t, cm.KeepGroupMembers("sre"))
+
+	assert.Equal(t, "admin:x:1002:1000:Admin:/home/admin:/bin/bash\nops:x:1003:1003::/home/ops:/bin/bash\n", writeCache(t, cm["passwd"]))
+	assert.Equal(t, "ops:*:::::::\n", writeCache(t, cm["shadow"]))
+	assert.Equal(t, "foo:*:1000:\nsre:*:2000:ops,admin\nwheel:*:10:admin,ops\n", writeCache(t, cm["group"]))
+
+	// By primary GID
+	cm = NewCaches()
+	assert.Nil(t, cm.FillCaches(&membersSource{}))
+	assert.Nil(t, cm.KeepGroupMembers("foo", "missing"))
+	assert.Equal(t, "foo:x:1000:1000:Mr Foo:/home/foo:/bin/bash\nbar:x:1001:1000:Mrs Bar:/home/bar:/bin/bash\nadmin:x:1002:1000:Admin:/home/admin:/bin/bash\n", writeCache(t, cm["passwd"]))
+	assert.Equal(t, "foo:*:::::::\n", writeCache(t, cm["shadow"]))
+	assert.Equal(t, "foo:*:1000:\nsre:*:2000:admin\nwheel:*:10:admin,foo\n", writeCache(t, cm["group"]))
+
+	cm = NewCaches()
+	assert.Nil(t, cm.FillCaches(&membersSource{}))
+	assert.Nil(t, cm.KeepGroupMembers())
+	assert.Equal(t, "", writeCache(t, cm["passwd"]))
+	assert.Equal(t, "foo:*:1000:\nsre:*:2000:\nwheel:*:10:\n", writeCache(t, cm["group"]))
+
+	delete(cm, "group")
+	assert.NotNil(t, cm.KeepGroupMembers("sre"))
+	delete(cm, "passwd")
+	assert.NotNil(t, cm.KeepGroupMembers("sre"))
+}
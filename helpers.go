@@ -12,10 +12,25 @@ import (
 // renaming it to the desired name.  On most Linux systems this will
 // be an atomic action.
 func WriteAtomic(filename string, wt io.WriterTo, perm os.FileMode) error {
+	tmp, err := writeTemp(filename, wt, perm)
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	// Any err should result in full cleanup.
+	if err != nil && tmp != "" {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// writeTemp writes the content to a new temporary file next to the
+// named file, with the provided permissions, and returns the name of
+// the temporary file.  The temporary file is removed on error.
+func writeTemp(filename string, wt io.WriterTo, perm os.FileMode) (string, error) {
 	dir, name := path.Split(filename)
 	f, err := ioutil.TempFile(dir, name)
 	if err != nil {
-		return err
+		return "", err
 	}
 	_, err = wt.WriteTo(f)
 	if err == nil {
@@ -27,12 +42,9 @@ func WriteAtomic(filename string, wt io.WriterTo, perm os.FileMode) error {
 	if permErr := os.Chmod(f.Name(), perm); err == nil {
 		err = permErr
 	}
-	if err == nil {
-		err = os.Rename(f.Name(), filename)
-	}
-	// Any err should result in full cleanup.
 	if err != nil {
 		os.Remove(f.Name())
+		return "", err
 	}
-	return err
+	return f.Name(), nil
 }
@@ -6,6 +6,7 @@ package nsscache
 
 import (
 	"fmt"
+	"io"
 	"path/filepath"
 
 	"os"
@@ -84,6 +85,12 @@ func defaultWriteOptions() WriteOptions {
 	}
 }
 
+// stagedFile is a file written to a temporary file, to be renamed to
+// its path.
+type stagedFile struct {
+	tmp, fpath string
+}
+
 // WriteFiles write the content of the cache structs into files that
 // libnss-cache can read.
 func (cm *CacheMap) WriteFiles(options *WriteOptions) error {
@@ -97,13 +104,29 @@ func (cm *CacheMap) WriteFiles(options *WriteOptions) error {
 		}
 	}
 
+	// Every file is written before any is renamed into place, so that
+	// an index which cannot be written, e.g. because of a name with a
+	// newline, leaves all the files as they were.
+	var staged []stagedFile
+	stage := func(fpath string, wt io.WriterTo, mode os.FileMode) error {
+		tmp, err := writeTemp(fpath, wt, mode)
+		if err != nil {
+			for _, f := range staged {
+				os.Remove(f.tmp)
+			}
+			return err
+		}
+		staged = append(staged, stagedFile{tmp: tmp, fpath: fpath})
+		return nil
+	}
+
 	for _, name := range []string{"passwd", "shadow", "group"} {
 		fpath := filepath.Join(wo.Directory, fmt.Sprintf("%s.%s", name, wo.Extension))
 		mode := 0644
 		if name == "shadow" {
 			mode = 0000
 		}
-		if err := WriteAtomic(fpath, (*cm)[name], os.FileMode(mode)); err != nil {
+		if err := stage(fpath, (*cm)[name], os.FileMode(mode)); err != nil {
 			return err
 		}
 	}
@@ -122,8 +145,16 @@ func (cm *CacheMap) WriteFiles(options *WriteOptions) error {
 
 	for _, idx := range idxCfg {
 		fpath := filepath.Join(wo.Directory, fmt.Sprintf("%s.%s.%s", idx.cache, wo.Extension, idx.supext))
-		idx := (*cm)[idx.cache].Index(idx.column)
-		if err := WriteAtomic(fpath, &idx, os.FileMode(0644)); err != nil {
+		if err := stage(fpath, (*cm)[idx.cache].IndexWriter(idx.column), os.FileMode(0644)); err != nil {
+			return err
+		}
+	}
+
+	for i, f := range staged {
+		if err := os.Rename(f.tmp, f.fpath); err != nil {
+			for _, f := range staged[i:] {
+				os.Remove(f.tmp)
+			}
 			return err
 		}
 	}
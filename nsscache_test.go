@@ -188,6 +188,32 @@ func TestCacheMap_WriteFiles(t *testing.T) {
 	}))
 }
 
+func TestCacheMap_WriteFiles_InvalidKey(t *testing.T) {
+	dir, err := os.MkdirTemp(os.TempDir(), "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cm := NewCaches()
+	assert.Nil(t, cm.FillCaches(&testSource{}))
+	assert.Nil(t, cm.WriteFiles(&WriteOptions{Directory: dir}))
+	before, err := os.ReadFile(filepath.Join(dir, "passwd.cache"))
+	assert.Nil(t, err)
+
+	// A name with a newline would inject a line in the cache file
+	cm["passwd"].Add(&cache.PasswdEntry{Name: "bar\nroot", Passwd: "x", UID: 0, GID: 0})
+	err = cm.WriteFiles(&WriteOptions{Directory: dir})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "invalid key")
+	}
+
+	after, err := os.ReadFile(filepath.Join(dir, "passwd.cache"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(before), string(after))
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 8)
+}
+
 func TestCacheMap_FillCaches(t *testing.T) {
 	cm := NewCaches()
 	src := testSource{}
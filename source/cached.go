@@ -0,0 +1,196 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/MiLk/nsscache-go/cache"
+)
+
+/*
+Cached describes a Source wrapping an upstream source, which fills the
+caches from the result of the last fill of every map until it expires:
+  - src: the upstream source
+  - ttl: the duration a successful result is kept
+  - negativeTTL: the duration a failure is kept
+  - dir: the directory where the successful results are kept
+  - now: the clock
+  - results: the last result of every map
+*/
+type Cached struct {
+	src         Source
+	ttl         time.Duration
+	negativeTTL time.Duration
+	dir         string
+	now         func() time.Time
+
+	mu      sync.Mutex
+	results map[string]*result
+}
+
+// result is the result of the last fill of a map from upstream.
+type result struct {
+	entries []cache.Entry
+	err     error
+	time    time.Time
+}
+
+// CachedOption represents a function which will make some change to
+// the cached source during initialization.
+type CachedOption func(*Cached)
+
+// NegativeTTL is an option function which will make the cached source
+// return the error of a failed fill, without querying the upstream
+// source again, for the provided duration.  The failures are not kept
+// if 0, the default.
+func NegativeTTL(d time.Duration) CachedOption {
+	return func(c *Cached) { c.negativeTTL = d }
+}
+
+// CacheDir is an option function which will make the cached source
+// keep the successful results in the provided directory, so that they
+// are reused across restarts until they expire.  The directory is
+// created if needed.
+func CacheDir(dir string) CachedOption {
+	return func(c *Cached) { c.dir = dir }
+}
+
+// NewCached creates a new Source filling the caches from the upstream
+// source at most once per TTL for every map.
+func NewCached(src Source, ttl time.Duration, opts ...CachedOption) (*Cached, error) {
+	c := Cached{
+		src:     src,
+		ttl:     ttl,
+		now:     time.Now,
+		results: map[string]*result{},
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	if c.dir != "" {
+		if err := os.MkdirAll(c.dir, 0700); err != nil {
+			return nil, fmt.Errorf("creating cache directory: %w", err)
+		}
+	}
+
+	return &c, nil
+}
+
+// Refresh makes the next fill of the named maps, or of all the maps if
+// none is provided, query the upstream source whatever the age of the
+// last result.
+func (c *Cached) Refresh(names ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(names) == 0 {
+		names = []string{"passwd", "shadow", "group"}
+	}
+	for _, name := range names {
+		c.results[name] = &result{}
+	}
+}
+
+// Age returns the age of the last successful result of the named map,
+// and false if there is none.
+func (c *Cached) Age(name string) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, ok := c.results[name]
+	if !ok || r.err != nil || r.time.IsZero() {
+		return 0, false
+	}
+	return c.now().Sub(r.time), true
+}
+
+// last returns the last result of the named map if it has not expired.
+// Without any result in memory, the result kept in the directory is
+// read.
+func (c *Cached) last(name string, createEntry func() cache.Entry) (*result, error) {
+	r, ok := c.results[name]
+	if !ok && c.dir != "" {
+		p := snapshotPath(c.dir, name)
+		info, err := os.Stat(p)
+		if err == nil && c.now().Sub(info.ModTime()) < c.ttl {
+			entries, err := loadSnapshot(p, createEntry)
+			if err != nil {
+				return nil, err
+			}
+			r = &result{entries: entries, time: info.ModTime()}
+			c.results[name] = r
+		}
+	}
+
+	switch {
+	case r == nil || r.time.IsZero():
+		return nil, nil
+	case r.err == nil && c.now().Sub(r.time) < c.ttl:
+		return r, nil
+	case r.err != nil && c.now().Sub(r.time) < c.negativeTTL:
+		return r, nil
+	default:
+		return nil, nil
+	}
+}
+
+func (c *Cached) fill(name string, dst *cache.Cache, fill func(Source, *cache.Cache) error, createEntry func() cache.Entry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r, err := c.last(name, createEntry)
+	if err != nil {
+		return err
+	}
+	if r == nil {
+		tmp := cache.NewCache()
+		r = &result{time: c.now()}
+		if r.err = fill(c.src, tmp); r.err == nil {
+			r.entries = tmp.Entries()
+			if c.dir != "" {
+				if err := saveSnapshot(c.dir, name, tmp); err != nil {
+					return err
+				}
+			}
+		}
+		c.results[name] = r
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	dst.Add(r.entries...)
+	return nil
+}
+
+// FillPasswdCache fills the passwd cache from the last result, or from
+// the upstream source if it expired.
+func (c *Cached) FillPasswdCache(dst *cache.Cache) error {
+	return c.fill("passwd", dst, func(s Source, c *cache.Cache) error {
+		return s.FillPasswdCache(c)
+	}, func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+}
+
+// FillShadowCache fills the shadow cache from the last result, or from
+// the upstream source if it expired.
+func (c *Cached) FillShadowCache(dst *cache.Cache) error {
+	return c.fill("shadow", dst, func(s Source, c *cache.Cache) error {
+		return s.FillShadowCache(c)
+	}, func() cache.Entry {
+		return &cache.ShadowEntry{}
+	})
+}
+
+// FillGroupCache fills the group cache from the last result, or from
+// the upstream source if it expired.
+func (c *Cached) FillGroupCache(dst *cache.Cache) error {
+	return c.fill("group", dst, func(s Source, c *cache.Cache) error {
+		return s.FillGroupCache(c)
+	}, func() cache.Entry {
+		return &cache.GroupEntry{}
+	})
+}
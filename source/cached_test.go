@@ -0,0 +1,134 @@
+package source
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// counting is a Source counting the fills of the wrapped source.
+type counting struct {
+	*static
+	fills int
+}
+
+func (c *counting) FillPasswdCache(dst *cache.Cache) error {
+	c.fills++
+	return c.static.FillPasswdCache(dst)
+}
+
+func (c *counting) FillGroupCache(dst *cache.Cache) error {
+	c.fills++
+	return c.static.FillGroupCache(dst)
+}
+
+func TestCached(t *testing.T) {
+	files, _, _ := testSources()
+	upstream := &counting{static: files}
+	now := time.Now()
+
+	c, err := NewCached(upstream, 5*time.Minute, NegativeTTL(time.Minute))
+	assert.Nil(t, err)
+	c.now = func() time.Time { return now }
+
+	_, ok := c.Age("passwd")
+	assert.False(t, ok)
+
+	passwd := "root:x:0:0::/home/root:/bin/bash\nbreakglass:x:999:999::/home/breakglass:/bin/bash\n"
+	assert.Equal(t, passwd, sourcetest.Fill(t, c.FillPasswdCache))
+	assert.Equal(t, 1, upstream.fills)
+
+	now = now.Add(4 * time.Minute)
+	assert.Equal(t, passwd, sourcetest.Fill(t, c.FillPasswdCache))
+	assert.Equal(t, 1, upstream.fills)
+	age, ok := c.Age("passwd")
+	assert.True(t, ok)
+	assert.Equal(t, 4*time.Minute, age)
+
+	// The maps expire separately
+	assert.Equal(t, "root:x:0:\n", sourcetest.Fill(t, c.FillGroupCache))
+	assert.Equal(t, 2, upstream.fills)
+
+	// Expired
+	now = now.Add(2 * time.Minute)
+	files.passwd = files.passwd[:1]
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\n", sourcetest.Fill(t, c.FillPasswdCache))
+	assert.Equal(t, 3, upstream.fills)
+
+	// Forced refresh
+	files.group = nil
+	c.Refresh("group")
+	assert.Equal(t, "", sourcetest.Fill(t, c.FillGroupCache))
+	assert.Equal(t, 4, upstream.fills)
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\n", sourcetest.Fill(t, c.FillPasswdCache))
+	assert.Equal(t, 4, upstream.fills)
+
+	// Negative cache
+	unavailable := errors.New("unavailable")
+	files.err = unavailable
+	c.Refresh()
+	assert.Equal(t, unavailable, c.FillPasswdCache(cache.NewCache()))
+	assert.Equal(t, 5, upstream.fills)
+	_, ok = c.Age("passwd")
+	assert.False(t, ok)
+
+	now = now.Add(30 * time.Second)
+	files.err = nil
+	assert.Equal(t, unavailable, c.FillPasswdCache(cache.NewCache()))
+	assert.Equal(t, 5, upstream.fills)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\n", sourcetest.Fill(t, c.FillPasswdCache))
+	assert.Equal(t, 6, upstream.fills)
+
+	// Without negative cache
+	c, err = NewCached(upstream, 5*time.Minute)
+	assert.Nil(t, err)
+	files.err = unavailable
+	assert.Equal(t, unavailable, c.FillPasswdCache(cache.NewCache()))
+	files.err = nil
+	assert.Nil(t, c.FillPasswdCache(cache.NewCache()))
+	assert.Equal(t, 8, upstream.fills)
+}
+
+func TestCached_CacheDir(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files, _, _ := testSources()
+	upstream := &counting{static: files}
+
+	c, err := NewCached(upstream, time.Minute, CacheDir(dir))
+	assert.Nil(t, err)
+	assert.Equal(t, "root:x:0:\n", sourcetest.Fill(t, c.FillGroupCache))
+	assert.Equal(t, 1, upstream.fills)
+
+	// A new cached source, e.g. after a restart, reuses the result
+	c, err = NewCached(upstream, time.Minute, CacheDir(dir))
+	assert.Nil(t, err)
+	assert.Equal(t, "root:x:0:\n", sourcetest.Fill(t, c.FillGroupCache))
+	assert.Equal(t, 1, upstream.fills)
+	_, ok := c.Age("group")
+	assert.True(t, ok)
+
+	c.Refresh()
+	assert.Equal(t, "root:x:0:\n", sourcetest.Fill(t, c.FillGroupCache))
+	assert.Equal(t, 2, upstream.fills)
+
+	// Expired
+	c, err = NewCached(upstream, time.Minute, CacheDir(dir))
+	assert.Nil(t, err)
+	c.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	assert.Equal(t, "root:x:0:\n", sourcetest.Fill(t, c.FillGroupCache))
+	assert.Equal(t, 3, upstream.fills)
+
+	_, err = NewCached(upstream, time.Minute, CacheDir("/dev/null/cache"))
+	assert.NotNil(t, err)
+}
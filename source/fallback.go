@@ -0,0 +1,144 @@
+package source
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/MiLk/nsscache-go/cache"
+)
+
+/*
+Fallback describes a Source wrapping a primary source, which keeps a
+snapshot of every map successfully filled and fills the caches from it
+when the primary source fails:
+  - src: the primary source
+  - dir: the directory of the snapshots
+  - maxAge: the age after which a snapshot is not used
+  - failures: the errors of the primary source for the maps filled from
+    the snapshots during the last fill, by map name
+*/
+type Fallback struct {
+	src      Source
+	dir      string
+	maxAge   time.Duration
+	failures map[string]error
+}
+
+// FallbackOption represents a function which will make some change to
+// the fallback during initialization.
+type FallbackOption func(*Fallback)
+
+// MaxAge is an option function which will make the fallback ignore the
+// snapshots older than the provided duration.  The snapshots are used
+// whatever their age if 0, the default.
+func MaxAge(d time.Duration) FallbackOption {
+	return func(f *Fallback) { f.maxAge = d }
+}
+
+// NewFallback creates a new Source filling the caches from the primary
+// source, and from the snapshots kept in the provided directory when
+// it fails.  The directory is created if needed.  The snapshots are
+// JSON arrays of entries named after the maps, e.g. `passwd.json`, and
+// are only readable by the owner since they include the shadow map.
+func NewFallback(src Source, dir string, opts ...FallbackOption) (*Fallback, error) {
+	f := Fallback{
+		src:      src,
+		dir:      dir,
+		failures: map[string]error{},
+	}
+
+	for _, opt := range opts {
+		opt(&f)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating snapshot directory: %w", err)
+	}
+
+	return &f, nil
+}
+
+// Failure returns the error of the primary source if the named map was
+// filled from its snapshot during the last fill, or nil.
+func (f *Fallback) Failure(name string) error {
+	return f.failures[name]
+}
+
+// Fallbacks returns the names of the maps filled from their snapshot
+// during the last fill, in alphabetical order.
+func (f *Fallback) Fallbacks() []string {
+	var names []string
+	for name := range f.failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *Fallback) fill(name string, c *cache.Cache, fill func(Source, *cache.Cache) error, createEntry func() cache.Entry) error {
+	delete(f.failures, name)
+
+	tmp := cache.NewCache()
+	err := fill(f.src, tmp)
+	if err == nil {
+		if err := saveSnapshot(f.dir, name, tmp); err != nil {
+			return err
+		}
+		c.Add(tmp.Entries()...)
+		return nil
+	}
+
+	entries, snapErr := f.load(name, createEntry)
+	if snapErr != nil {
+		return fmt.Errorf("%w (no fallback: %s)", err, snapErr)
+	}
+	f.failures[name] = err
+	c.Add(entries...)
+	return nil
+}
+
+// load reads the entries of the snapshot of the named map, unless it
+// is too old.
+func (f *Fallback) load(name string, createEntry func() cache.Entry) ([]cache.Entry, error) {
+	p := snapshotPath(f.dir, name)
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if f.maxAge > 0 && time.Since(info.ModTime()) > f.maxAge {
+		return nil, fmt.Errorf("snapshot %s is older than %s", p, f.maxAge)
+	}
+	return loadSnapshot(p, createEntry)
+}
+
+// FillPasswdCache fills the passwd cache from the primary source, or
+// from the snapshot if it fails.
+func (f *Fallback) FillPasswdCache(c *cache.Cache) error {
+	return f.fill("passwd", c, func(s Source, c *cache.Cache) error {
+		return s.FillPasswdCache(c)
+	}, func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+}
+
+// FillShadowCache fills the shadow cache from the primary source, or
+// from the snapshot if it fails.
+func (f *Fallback) FillShadowCache(c *cache.Cache) error {
+	return f.fill("shadow", c, func(s Source, c *cache.Cache) error {
+		return s.FillShadowCache(c)
+	}, func() cache.Entry {
+		return &cache.ShadowEntry{}
+	})
+}
+
+// FillGroupCache fills the group cache from the primary source, or
+// from the snapshot if it fails.
+func (f *Fallback) FillGroupCache(c *cache.Cache) error {
+	return f.fill("group", c, func(s Source, c *cache.Cache) error {
+		return s.FillGroupCache(c)
+	}, func() cache.Entry {
+		return &cache.GroupEntry{}
+	})
+}
@@ -0,0 +1,111 @@
+package source
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	primary, _, _ := testSources()
+	primary.shadow = []cache.Entry{&cache.ShadowEntry{Name: "breakglass", Passwd: "$6$local", Lstchg: cache.Int32(18000)}}
+	primary.group = []cache.Entry{&cache.GroupEntry{Name: "root", GID: 0, Mem: []string{"breakglass"}}}
+
+	f, err := NewFallback(primary, filepath.Join(dir, "snapshots"))
+	assert.Nil(t, err)
+
+	passwd := "root:x:0:0::/home/root:/bin/bash\nbreakglass:x:999:999::/home/breakglass:/bin/bash\n"
+	shadow := "breakglass:$6$local:18000::::::\n"
+	group := "root:x:0:breakglass\n"
+	assert.Equal(t, passwd, sourcetest.Fill(t, f.FillPasswdCache))
+	assert.Equal(t, shadow, sourcetest.Fill(t, f.FillShadowCache))
+	assert.Equal(t, group, sourcetest.Fill(t, f.FillGroupCache))
+	assert.Empty(t, f.Fallbacks())
+
+	info, err := os.Stat(filepath.Join(dir, "snapshots", "shadow.json"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// The snapshots hold the data of the primary source
+	snapshots, err := NewSnapshots(filepath.Join(dir, "snapshots"))
+	assert.Nil(t, err)
+	assert.Equal(t, passwd, sourcetest.Fill(t, snapshots.FillPasswdCache))
+	assert.Equal(t, shadow, sourcetest.Fill(t, snapshots.FillShadowCache))
+	assert.Equal(t, group, sourcetest.Fill(t, snapshots.FillGroupCache))
+	_, err = NewSnapshots(filepath.Join(dir, "missing"))
+	assert.NotNil(t, err)
+	_, err = NewSnapshots(filepath.Join(dir, "snapshots", "group.json"))
+	assert.NotNil(t, err)
+
+	unavailable := errors.New("unavailable")
+	primary.err = unavailable
+
+	// A new fallback on the same directory, e.g. after a rebuild
+	f, err = NewFallback(primary, filepath.Join(dir, "snapshots"))
+	assert.Nil(t, err)
+	assert.Equal(t, passwd, sourcetest.Fill(t, f.FillPasswdCache))
+	assert.Equal(t, shadow, sourcetest.Fill(t, f.FillShadowCache))
+	assert.Equal(t, group, sourcetest.Fill(t, f.FillGroupCache))
+	assert.Equal(t, []string{"group", "passwd", "shadow"}, f.Fallbacks())
+	assert.Equal(t, unavailable, f.Failure("passwd"))
+
+	primary.err = nil
+	primary.group = nil
+	assert.Equal(t, "", sourcetest.Fill(t, f.FillGroupCache))
+	assert.Equal(t, []string{"passwd", "shadow"}, f.Fallbacks())
+	assert.Nil(t, f.Failure("group"))
+
+	// The empty group map was saved
+	primary.err = unavailable
+	assert.Equal(t, "", sourcetest.Fill(t, f.FillGroupCache))
+	assert.Equal(t, []string{"group", "passwd", "shadow"}, f.Fallbacks())
+}
+
+func TestFallback_NoSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	unavailable := errors.New("unavailable")
+	primary := &static{err: unavailable}
+	f, err := NewFallback(primary, dir, MaxAge(time.Hour))
+	assert.Nil(t, err)
+
+	err = f.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.True(t, errors.Is(err, unavailable))
+		assert.Contains(t, err.Error(), "unavailable (no fallback: ")
+	}
+
+	// Stale snapshot
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "group.json"), []byte(`[{"name": "foo", "gid": 1000}]`), 0600))
+	assert.Equal(t, "foo:x:1000:\n", sourcetest.Fill(t, f.FillGroupCache))
+	old := time.Now().Add(-2 * time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(dir, "group.json"), old, old))
+	err = f.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "group.json is older than 1h0m0s")
+	}
+
+	// Corrupted snapshot
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "shadow.json"), []byte(`[{"login": "foo"}]`), 0600))
+	err = f.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "shadow.json at index 0")
+	}
+
+	_, err = NewFallback(primary, "/dev/null/snapshots")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,148 @@
+// Package files implements a source.Source reading the standard
+// colon-separated /etc/passwd, /etc/shadow and /etc/group files.
+//
+// The `+` and `-` lines of NIS compat mode, e.g. `+@netgroup` or
+// `-foo`, are ignored by the source: the entries they include or
+// exclude are looked up in NIS by the C library, so the caches only
+// hold the local entries.
+package files
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/pkg/errors"
+)
+
+/*
+Source describes a source.Source for local files:
+  - passwd: the path to the passwd file
+  - shadow: the path to the shadow file
+  - group: the path to the group file
+*/
+type Source struct {
+	passwd string
+	shadow string
+	group  string
+}
+
+// Option represents a function which will make some change to the
+// source during initialization.
+type Option func(*Source)
+
+// Passwd is an option function which will set the path to the passwd
+// file.  Defaults to `/etc/passwd`.
+func Passwd(path string) Option {
+	return func(s *Source) { s.passwd = path }
+}
+
+// Shadow is an option function which will set the path to the shadow
+// file.  Defaults to `/etc/shadow`.
+func Shadow(path string) Option {
+	return func(s *Source) { s.shadow = path }
+}
+
+// Group is an option function which will set the path to the group
+// file.  Defaults to `/etc/group`.
+func Group(path string) Option {
+	return func(s *Source) { s.group = path }
+}
+
+// NewSource creates a new files source using the options provided.
+func NewSource(opts ...Option) (*Source, error) {
+	s := Source{
+		passwd: "/etc/passwd",
+		shadow: "/etc/shadow",
+		group:  "/etc/group",
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s, nil
+}
+
+// parser is satisfied by the entries which can be read from a line of
+// a colon-separated file.
+type parser interface {
+	Parse(line string) error
+}
+
+// Parse reads the lines of a colon-separated file from r and adds the
+// entries created by newEntry to the cache.  Empty lines and comments
+// are ignored.  Unlike the source, the lines of NIS compat mode are
+// parsed as entries, and most of them fail to parse.
+func Parse(r io.Reader, c *cache.Cache, newEntry func() cache.Entry) error {
+	return parse(r, c, newEntry, false)
+}
+
+// parse reads the lines of a colon-separated file as Parse does, also
+// ignoring the `+` and `-` lines of NIS compat mode if nisCompat is
+// true.
+func parse(r io.Reader, c *cache.Cache, newEntry func() cache.Entry, nisCompat bool) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if !skip(line, nisCompat) {
+			e := newEntry()
+			p, ok := e.(parser)
+			if !ok {
+				return errors.Errorf("entries of type %T cannot be parsed", e)
+			}
+			if err := p.Parse(line); err != nil {
+				return errors.Wrapf(err, "parsing line %d", n)
+			}
+			c.Add(e)
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// skip returns true if the line holds no entry.
+func skip(line string, nisCompat bool) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return true
+	}
+	return nisCompat && (strings.HasPrefix(trimmed, "+") || strings.HasPrefix(trimmed, "-"))
+}
+
+func (s *Source) run(fpath string, c *cache.Cache, newEntry func() cache.Entry) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return errors.Wrap(parse(f, c, newEntry, true), fpath)
+}
+
+// FillPasswdCache reads the passwd file and fills the passwd cache.
+func (s *Source) FillPasswdCache(c *cache.Cache) error {
+	return s.run(s.passwd, c, func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+}
+
+// FillShadowCache reads the shadow file and fills the shadow cache.
+func (s *Source) FillShadowCache(c *cache.Cache) error {
+	return s.run(s.shadow, c, func() cache.Entry {
+		return &cache.ShadowEntry{}
+	})
+}
+
+// FillGroupCache reads the group file and fills the group cache.
+func (s *Source) FillGroupCache(c *cache.Cache) error {
+	return s.run(s.group, c, func() cache.Entry {
+		return &cache.GroupEntry{}
+	})
+}
@@ -0,0 +1,81 @@
+package files
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MiLk/nsscache-go"
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource_FillCaches(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"passwd": "root:x:0:0:root:/root:/bin/bash\n# comment\n\nfoo:x:1000:1000:Foo Bar,,,:/home/foo:/bin/bash\n+@netgroup::::::\n-bar::::::\n+\n",
+		"shadow": "root:*:18000:0:99999:7:::\nfoo:$6$hash::::::19000:\n",
+		"group":  "root:x:0:\nusers:x:100:foo,,bar\n",
+	}
+	for name, content := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	src, err := NewSource(
+		Passwd(filepath.Join(dir, "passwd")),
+		Shadow(filepath.Join(dir, "shadow")),
+		Group(filepath.Join(dir, "group")),
+	)
+	assert.Nil(t, err)
+
+	cm := nsscache.NewCaches()
+	assert.Nil(t, cm.FillCaches(src))
+
+	expected := map[string]string{
+		"passwd": "root:x:0:0:root:/root:/bin/bash\nfoo:x:1000:1000:Foo Bar,,,:/home/foo:/bin/bash\n",
+		"shadow": "root:*:18000:0:99999:7:::\nfoo:$6$hash::::::19000:\n",
+		"group":  "root:x:0:\nusers:x:100:foo,bar\n",
+	}
+	for name, c := range cm {
+		var b bytes.Buffer
+		_, err := c.WriteTo(&b)
+		assert.Nil(t, err)
+		assert.Equal(t, expected[name], b.String(), name)
+	}
+}
+
+func TestSource_Errors(t *testing.T) {
+	src, err := NewSource(Passwd("/does/not/exist"))
+	assert.Nil(t, err)
+	assert.NotNil(t, src.FillPasswdCache(cache.NewCache()))
+
+	c := cache.NewCache()
+	err = Parse(strings.NewReader("foo:x:1000:1000::/home/foo:/bin/bash\nbar:x:abc:1000::/home/bar:/bin/bash\n"), c, func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "parsing line 2")
+	}
+
+	// Only the files source ignores the lines of NIS compat mode.
+	err = Parse(strings.NewReader("+@netgroup::::::\n"), cache.NewCache(), func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "parsing line 1")
+	}
+}
+
+func TestNewSource_Defaults(t *testing.T) {
+	src, err := NewSource()
+	assert.Nil(t, err)
+	assert.Equal(t, "/etc/passwd", src.passwd)
+	assert.Equal(t, "/etc/shadow", src.shadow)
+	assert.Equal(t, "/etc/group", src.group)
+}
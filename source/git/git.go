@@ -0,0 +1,257 @@
+// Package git implements a source.Source reading the maps, as JSON or
+// YAML lists of entries, from the files committed to a local git
+// repository.
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// extensions are the extensions of the map files looked up in the
+// directory, in order of preference.
+var extensions = []string{".json", ".yaml", ".yml"}
+
+/*
+Source describes a source.Source for a local git repository:
+  - repo: the repository
+  - ref: the revision the maps are read at
+  - dir: the directory of the map files in the repository
+  - mapFiles: the paths of specific map files in the repository
+  - keyRings: the armored public keys trusted to sign the commits
+*/
+type Source struct {
+	repo     *gogit.Repository
+	ref      string
+	dir      string
+	mapFiles map[string]string
+	keyRings []string
+
+	mu     sync.Mutex
+	commit *object.Commit
+	filled map[string]bool
+}
+
+// Option represents a function which will make some change to the
+// source during initialization.
+type Option func(*Source)
+
+// Ref is an option function which will set the revision the maps are
+// read at, e.g. a branch, a tag or a commit hash.  Defaults to `HEAD`.
+func Ref(ref string) Option {
+	return func(s *Source) { s.ref = ref }
+}
+
+// Dir is an option function which will set the directory of the map
+// files in the repository.  The maps are read from the first of
+// `<dir>/<map>.json`, `<dir>/<map>.yaml` and `<dir>/<map>.yml` found.
+// Defaults to the root of the repository.
+func Dir(dir string) Option {
+	return func(s *Source) { s.dir = dir }
+}
+
+// MapFile is an option function which will set the path of the named
+// map file in the repository.  The format is taken from the extension,
+// YAML for `.yaml` and `.yml` and JSON otherwise.
+func MapFile(name, path string) Option {
+	return func(s *Source) { s.mapFiles[name] = path }
+}
+
+// TrustedKeys is an option function which will make the source refuse
+// the commits which are not signed by one of the keys of the provided
+// armored OpenPGP key rings.
+func TrustedKeys(armoredKeyRings ...string) Option {
+	return func(s *Source) { s.keyRings = append(s.keyRings, armoredKeyRings...) }
+}
+
+// NewSource creates a new git source for the repository at the
+// provided path using the options provided.  The repository may be
+// bare.
+func NewSource(repoPath string, opts ...Option) (*Source, error) {
+	repo, err := gogit.PlainOpen(repoPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening repository %s", repoPath)
+	}
+
+	s := Source{
+		repo:     repo,
+		ref:      "HEAD",
+		mapFiles: map[string]string{},
+		filled:   map[string]bool{},
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s, nil
+}
+
+// Commit returns the hash of the commit the caches were last filled
+// from, or an empty string if none was filled yet.
+func (s *Source) Commit() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.commit == nil {
+		return ""
+	}
+	return s.commit.Hash.String()
+}
+
+// Resolve resolves the revision of the source to a commit, which the
+// following fills read the maps from.  The commit is verified against
+// the trusted keys, if any.  It is called on the first fill, and again
+// when a map already filled from the current commit is filled again,
+// so that all the caches of a run come from the same commit.
+func (s *Source) Resolve() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resolve()
+}
+
+func (s *Source) resolve() error {
+	h, err := s.repo.ResolveRevision(plumbing.Revision(s.ref))
+	if err != nil {
+		return errors.Wrapf(err, "resolving %s", s.ref)
+	}
+	commit, err := s.repo.CommitObject(*h)
+	if err != nil {
+		return errors.Wrapf(err, "reading commit %s", h)
+	}
+	if len(s.keyRings) > 0 {
+		if err := verify(commit, s.keyRings); err != nil {
+			return errors.Wrapf(err, "commit %s is not signed by a trusted key", h)
+		}
+	}
+
+	s.commit = commit
+	s.filled = map[string]bool{}
+	return nil
+}
+
+// verify checks that the commit is signed by a key of one of the key
+// rings, and returns the last verification error otherwise.
+func verify(commit *object.Commit, keyRings []string) error {
+	if commit.PGPSignature == "" {
+		return errors.New("no signature")
+	}
+	var err error
+	for _, k := range keyRings {
+		if _, err = commit.Verify(k); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// mapCommit returns the commit to read the named map from.
+func (s *Source) mapCommit(name string) (*object.Commit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.commit == nil || s.filled[name] {
+		if err := s.resolve(); err != nil {
+			return nil, err
+		}
+	}
+	s.filled[name] = true
+	return s.commit, nil
+}
+
+// mapFile returns the file of the named map in the commit.
+func (s *Source) mapFile(commit *object.Commit, name string) (*object.File, error) {
+	if p, ok := s.mapFiles[name]; ok {
+		f, err := commit.File(p)
+		return f, errors.Wrapf(err, "reading %s", p)
+	}
+
+	for _, ext := range extensions {
+		p := path.Join(s.dir, name+ext)
+		f, err := commit.File(p)
+		if err == object.ErrFileNotFound {
+			continue
+		}
+		return f, errors.Wrapf(err, "reading %s", p)
+	}
+	return nil, errors.Errorf("no file found for %s in %s at commit %s", name, path.Join(s.dir, "."), commit.Hash)
+}
+
+func (s *Source) run(name string, c *cache.Cache, createEntry func() cache.Entry) error {
+	commit, err := s.mapCommit(name)
+	if err != nil {
+		return err
+	}
+	f, err := s.mapFile(commit, name)
+	if err != nil {
+		return err
+	}
+
+	r, err := f.Reader()
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", f.Name)
+	}
+	defer r.Close()
+
+	return errors.Wrap(decodeEntries(f.Name, r, c, createEntry), f.Name)
+}
+
+// decodeEntries decodes the list of entries of the file, in the
+// format given by its extension, and adds them to the cache.
+func decodeEntries(name string, r io.Reader, c *cache.Cache, createEntry func() cache.Entry) error {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".yaml", ".yml":
+		// The entries only have JSON field names, so the YAML list is
+		// converted to a JSON array before being decoded.
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrap(err, "reading")
+		}
+		var list []interface{}
+		if err := yaml.Unmarshal(b, &list); err != nil {
+			return errors.Wrap(err, "yaml decoding")
+		}
+		if list == nil {
+			list = []interface{}{}
+		}
+		raw, err := json.Marshal(list)
+		if err != nil {
+			return errors.Wrap(err, "yaml decoding")
+		}
+		r = bytes.NewReader(raw)
+	}
+
+	return source.DecodeEntries(r, c, createEntry)
+}
+
+// FillPasswdCache reads the passwd map and fills the passwd cache.
+func (s *Source) FillPasswdCache(c *cache.Cache) error {
+	return s.run("passwd", c, func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+}
+
+// FillShadowCache reads the shadow map and fills the shadow cache.
+func (s *Source) FillShadowCache(c *cache.Cache) error {
+	return s.run("shadow", c, func() cache.Entry {
+		return &cache.ShadowEntry{}
+	})
+}
+
+// FillGroupCache reads the group map and fills the group cache.
+func (s *Source) FillGroupCache(c *cache.Cache) error {
+	return s.run("group", c, func() cache.Entry {
+		return &cache.GroupEntry{}
+	})
+}
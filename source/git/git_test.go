@@ -0,0 +1,211 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+type repository struct {
+	t    *testing.T
+	dir  string
+	repo *gogit.Repository
+}
+
+func newRepository(t *testing.T) *repository {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	repo, err := gogit.PlainInit(dir, false)
+	assert.Nil(t, err)
+	return &repository{t: t, dir: dir, repo: repo}
+}
+
+func (r *repository) Close() {
+	os.RemoveAll(r.dir)
+}
+
+// commit writes the files and commits them, signed with the key if
+// any, and returns the hash of the commit.
+func (r *repository) commit(files map[string]string, key *openpgp.Entity) string {
+	w, err := r.repo.Worktree()
+	assert.Nil(r.t, err)
+	for name, content := range files {
+		p := filepath.Join(r.dir, name)
+		assert.Nil(r.t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.Nil(r.t, ioutil.WriteFile(p, []byte(content), 0644))
+		_, err := w.Add(name)
+		assert.Nil(r.t, err)
+	}
+	h, err := w.Commit("Update the maps", &gogit.CommitOptions{
+		Author:  &object.Signature{Name: "foo", Email: "foo@example.com", When: time.Now()},
+		SignKey: key,
+	})
+	assert.Nil(r.t, err)
+	return h.String()
+}
+
+func TestSource(t *testing.T) {
+	r := newRepository(t)
+	defer r.Close()
+
+	first := r.commit(map[string]string{
+		"passwd.json": `[{"name": "foo", "passwd": "x", "uid": 1000, "gid": 1000, "dir": "/home/foo", "shell": "/bin/bash"}]`,
+		"shadow.yml":  "- name: foo\n  passwd: '*'\n",
+		"group.yaml":  "- name: foo\n  gid: 1000\n  mem: [foo]\n",
+	}, nil)
+
+	src, err := NewSource(r.dir)
+	assert.Nil(t, err)
+	assert.Equal(t, "", src.Commit())
+
+	assert.Equal(t, "foo:x:1000:1000::/home/foo:/bin/bash\n", sourcetest.Fill(t, src.FillPasswdCache))
+	assert.Equal(t, "foo:*:::::::\n", sourcetest.Fill(t, src.FillShadowCache))
+	assert.Equal(t, "foo:x:1000:foo\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.Equal(t, first, src.Commit())
+
+	second := r.commit(map[string]string{
+		"group.yaml": "- name: foo\n  gid: 1000\n  mem: [foo, bar]\n",
+	}, nil)
+
+	// The commit is resolved again when a map is filled again
+	assert.Equal(t, "foo:x:1000:foo,bar\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.Equal(t, second, src.Commit())
+
+	// Pinned to the first commit
+	src, err = NewSource(r.dir, Ref(first))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:foo\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.Equal(t, first, src.Commit())
+}
+
+func TestSource_Files(t *testing.T) {
+	r := newRepository(t)
+	defer r.Close()
+
+	r.commit(map[string]string{
+		"nss/group.yml":     "- name: foo\n  gid: 1000\n",
+		"nss/group.json":    `[{"name": "bar", "gid": 1001}]`,
+		"users/people.yaml": "- {name: foo, passwd: x, uid: 1000, gid: 1000, dir: /home/foo, shell: /bin/sh}\n",
+	}, nil)
+
+	src, err := NewSource(r.dir, Dir("nss"), MapFile("passwd", "users/people.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "bar:x:1001:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.Equal(t, "foo:x:1000:1000::/home/foo:/bin/sh\n", sourcetest.Fill(t, src.FillPasswdCache))
+
+	err = src.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "no file found for shadow in nss")
+	}
+
+	src, err = NewSource(r.dir, MapFile("group", "nss/missing.json"))
+	assert.Nil(t, err)
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "reading nss/missing.json: file not found", err.Error())
+	}
+}
+
+func TestSource_ShadowNumbers(t *testing.T) {
+	r := newRepository(t)
+	defer r.Close()
+
+	r.commit(map[string]string{
+		"shadow.yaml":     "- name: foo\n  passwd: '*'\n  lstchg: 19000\n  min: 0\n  max: 99999\n  warn: '7'\n- name: bar\n  passwd: '!'\n  expire: 20000\n",
+		"nss/shadow.json": `[{"name": "foo", "passwd": "*", "lstchg": 19000, "max": "99999"}]`,
+	}, nil)
+
+	src, err := NewSource(r.dir)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:*:19000:0:99999:7:::\nbar:!::::::20000:\n", sourcetest.Fill(t, src.FillShadowCache))
+
+	src, err = NewSource(r.dir, Dir("nss"))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:*:19000::99999::::\n", sourcetest.Fill(t, src.FillShadowCache))
+}
+
+func TestSource_Errors(t *testing.T) {
+	_, err := NewSource("/does/not/exist")
+	assert.NotNil(t, err)
+
+	r := newRepository(t)
+	defer r.Close()
+
+	r.commit(map[string]string{
+		"passwd.json": `{"name": "foo"}`,
+		"shadow.yaml": "- name: [foo\n",
+		"group.yaml":  "- name: foo\n  gid: bar\n",
+	}, nil)
+
+	src, err := NewSource(r.dir)
+	assert.Nil(t, err)
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "passwd.json: json decoding")
+	}
+	err = src.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "shadow.yaml: yaml decoding")
+	}
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "group.yaml: json does not match entry format at index 0")
+	}
+
+	src, err = NewSource(r.dir, Ref("unknown"))
+	assert.Nil(t, err)
+	assert.NotNil(t, src.Resolve())
+}
+
+func armoredPublicKey(t *testing.T, e *openpgp.Entity) string {
+	var b bytes.Buffer
+	w, err := armor.Encode(&b, openpgp.PublicKeyType, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, e.Serialize(w))
+	assert.Nil(t, w.Close())
+	return b.String()
+}
+
+func TestSource_TrustedKeys(t *testing.T) {
+	trusted, err := openpgp.NewEntity("foo", "", "foo@example.com", nil)
+	assert.Nil(t, err)
+	other, err := openpgp.NewEntity("bar", "", "bar@example.com", nil)
+	assert.Nil(t, err)
+
+	r := newRepository(t)
+	defer r.Close()
+
+	signed := r.commit(map[string]string{"group.json": `[{"name": "foo", "gid": 1000}]`}, trusted)
+
+	src, err := NewSource(r.dir, TrustedKeys(armoredPublicKey(t, trusted)))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.Equal(t, signed, src.Commit())
+
+	// Unsigned
+	unsigned := r.commit(map[string]string{"group.json": `[{"name": "bar", "gid": 1001}]`}, nil)
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "commit "+unsigned+" is not signed by a trusted key: no signature", err.Error())
+	}
+
+	// Signed by another key
+	r.commit(map[string]string{"group.json": `[{"name": "baz", "gid": 1002}]`}, other)
+	assert.NotNil(t, src.Resolve())
+
+	// Any of the trusted keys
+	src, err = NewSource(r.dir, TrustedKeys(armoredPublicKey(t, trusted), armoredPublicKey(t, other)))
+	assert.Nil(t, err)
+	assert.Equal(t, "baz:x:1002:\n", sourcetest.Fill(t, src.FillGroupCache))
+}
@@ -0,0 +1,76 @@
+package source
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/MiLk/nsscache-go/cache"
+)
+
+// DecodeEntries decodes the JSON array of entries read from r, one
+// element at a time, and adds them to the cache.  This is the format
+// of the maps stored by most sources.
+func DecodeEntries(r io.Reader, c *cache.Cache, createEntry func() cache.Entry) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("json decoding: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errors.New("json decoding: expected an array of entries")
+	}
+
+	for i := 0; dec.More(); i++ {
+		e := createEntry()
+		if err := dec.Decode(e); err != nil {
+			return DecodingError(err, i)
+		}
+		c.Add(e)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("json decoding: %w", err)
+	}
+	return nil
+}
+
+// DecodingError describes the error decoding the JSON entry at the
+// given index, telling documents that are not JSON apart from the ones
+// which do not match the format of the entries.
+func DecodingError(err error, i int) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Errorf("json does not match entry format at index %d: %w", i, err)
+	}
+	return fmt.Errorf("json decoding at index %d: %w", i, err)
+}
+
+// ReplaceFile atomically replaces the content of the file at fpath
+// with the content of the reader, by writing to a temporary file
+// readable by the owner only and renaming it.
+func ReplaceFile(fpath string, r io.Reader) error {
+	dir, name := filepath.Split(fpath)
+	f, err := ioutil.TempFile(dir, name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), fpath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
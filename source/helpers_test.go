@@ -0,0 +1,65 @@
+package source
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeEntries(t *testing.T) {
+	for _, tc := range []struct {
+		body        string
+		expected    string
+		expectedErr string
+	}{
+		{`[{"name": "foo", "gid": 1000}, {"name": "bar", "gid": 1001}]`, "foo:x:1000:\nbar:x:1001:\n", ""},
+		{`[]`, "", ""},
+		{`{"name": "foo"}`, "", "json decoding: expected an array of entries"},
+		{`[{"name": "foo", "gid": "1000"}]`, "", "json does not match entry format at index 0: "},
+		{`[{"name": "foo", "gid": 1000}, {"name": "bar", "gid": 1001}`, "", "json decoding"},
+	} {
+		c := cache.NewCache()
+		err := DecodeEntries(strings.NewReader(tc.body), c, func() cache.Entry {
+			return &cache.GroupEntry{}
+		})
+		// The text of the encoding/json errors depends on the version
+		// of Go, only the prefix is checked.
+		if tc.expectedErr != "" {
+			if assert.NotNil(t, err, tc.body) {
+				assert.True(t, strings.HasPrefix(err.Error(), tc.expectedErr), err.Error())
+			}
+			continue
+		}
+		assert.Nil(t, err)
+		var b strings.Builder
+		_, err = c.WriteTo(&b)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expected, b.String())
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "state.json")
+
+	assert.Nil(t, ReplaceFile(fpath, strings.NewReader("first")))
+	assert.Nil(t, ReplaceFile(fpath, strings.NewReader("second")))
+	b, err := ioutil.ReadFile(fpath)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(b))
+
+	fi, err := os.Stat(fpath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// Nothing is left behind on failure
+	assert.NotNil(t, ReplaceFile(filepath.Join(dir, "missing", "state.json"), strings.NewReader("")))
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 1)
+}
@@ -0,0 +1,217 @@
+// Package http implements a source.Source fetching the maps, as JSON
+// arrays of entries, from HTTP(S) endpoints.
+package http
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	gohttp "net/http"
+	"strings"
+	"sync"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source"
+	"github.com/pkg/errors"
+)
+
+/*
+Source describes a source.Source for HTTP(S) endpoints:
+  - client: the HTTP client
+  - url: the template of the URLs of the maps
+  - mapURLs: the URLs of specific maps
+  - bearerToken: the token sent in the Authorization header
+  - username: the user name for basic authentication
+  - password: the password for basic authentication
+  - certFile: the client certificate
+  - keyFile: the private key of the client certificate
+  - caFile: the CA bundle verifying the server certificate
+  - stateDir: the directory where the responses are kept
+*/
+type Source struct {
+	client      *gohttp.Client
+	url         string
+	mapURLs     map[string]string
+	bearerToken string
+	username    string
+	password    string
+	certFile    string
+	keyFile     string
+	caFile      string
+	stateDir    string
+
+	mu        sync.Mutex
+	responses map[string]*response
+	unchanged map[string]bool
+}
+
+// Option represents a function which will make some change to the
+// source during initialization.
+type Option func(*Source)
+
+// URL is an option function which will set the template of the URLs of
+// the maps.  The `{map}` placeholder is replaced by the name of the
+// map, e.g. `https://users.example.com/nss/{map}.json`.
+func URL(template string) Option {
+	return func(s *Source) { s.url = template }
+}
+
+// MapURL is an option function which will set the URL of the named
+// map, overriding the URL template.
+func MapURL(name, url string) Option {
+	return func(s *Source) { s.mapURLs[name] = url }
+}
+
+// Client is an option function which will make the source use the
+// provided HTTP client.  The TLS options are ignored.
+func Client(c *gohttp.Client) Option {
+	return func(s *Source) { s.client = c }
+}
+
+// BearerToken is an option function which will make the source send
+// the provided token in the Authorization header of the requests.
+func BearerToken(token string) Option {
+	return func(s *Source) { s.bearerToken = token }
+}
+
+// BasicAuth is an option function which will make the source
+// authenticate with the provided user name and password.
+func BasicAuth(username, password string) Option {
+	return func(s *Source) {
+		s.username = username
+		s.password = password
+	}
+}
+
+// ClientCertificate is an option function which will make the source
+// present the certificate in the provided PEM files to the server.
+func ClientCertificate(certFile, keyFile string) Option {
+	return func(s *Source) {
+		s.certFile = certFile
+		s.keyFile = keyFile
+	}
+}
+
+// CABundle is an option function which will make the source verify the
+// certificate of the server with the CAs in the provided PEM file
+// instead of the system roots.
+func CABundle(caFile string) Option {
+	return func(s *Source) { s.caFile = caFile }
+}
+
+// StateDir is an option function which will make the source keep the
+// last response for every map in the provided directory, so that the
+// requests stay conditional across restarts.  The responses are always
+// kept in memory.  See Unchanged.
+func StateDir(dir string) Option {
+	return func(s *Source) { s.stateDir = dir }
+}
+
+// NewSource creates a new HTTP source using the options provided.
+func NewSource(opts ...Option) (*Source, error) {
+	s := Source{
+		mapURLs:   map[string]string{},
+		responses: map[string]*response{},
+		unchanged: map[string]bool{},
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	if s.client == nil {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := gohttp.DefaultTransport.(*gohttp.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		s.client = &gohttp.Client{Transport: transport}
+	}
+
+	return &s, nil
+}
+
+// tlsConfig returns the TLS configuration with the client certificate
+// and CA bundle.
+func (s *Source) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if s.certFile != "" || s.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "loading client certificate")
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if s.caFile != "" {
+		b, err := ioutil.ReadFile(s.caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading CA bundle")
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificate found in %s", s.caFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// Unchanged returns true if the named map was not modified since the
+// previous request during the last fill, in which case the cache was
+// filled from the previous response.
+func (s *Source) Unchanged(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unchanged[name]
+}
+
+// mapURL returns the URL of the named map.
+func (s *Source) mapURL(name string) (string, error) {
+	if u, ok := s.mapURLs[name]; ok {
+		return u, nil
+	}
+	if s.url == "" {
+		return "", errors.Errorf("no URL configured for %s", name)
+	}
+	return strings.ReplaceAll(s.url, "{map}", name), nil
+}
+
+func (s *Source) run(name string, c *cache.Cache, createEntry func() cache.Entry) error {
+	u, err := s.mapURL(name)
+	if err != nil {
+		return err
+	}
+
+	body, unchanged, err := s.fetch(u)
+	if err != nil {
+		return errors.Wrapf(err, "fetching %s", name)
+	}
+	s.mu.Lock()
+	s.unchanged[name] = unchanged
+	s.mu.Unlock()
+
+	return source.DecodeEntries(bytes.NewReader(body), c, createEntry)
+}
+
+// FillPasswdCache fetches the passwd map and fills the passwd cache.
+func (s *Source) FillPasswdCache(c *cache.Cache) error {
+	return s.run("passwd", c, func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+}
+
+// FillShadowCache fetches the shadow map and fills the shadow cache.
+func (s *Source) FillShadowCache(c *cache.Cache) error {
+	return s.run("shadow", c, func() cache.Entry {
+		return &cache.ShadowEntry{}
+	})
+}
+
+// FillGroupCache fetches the group map and fills the group cache.
+func (s *Source) FillGroupCache(c *cache.Cache) error {
+	return s.run("group", c, func() cache.Entry {
+		return &cache.GroupEntry{}
+	})
+}
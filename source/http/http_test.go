@@ -0,0 +1,276 @@
+package http
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	gohttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// mapServer serves the maps, answering conditional requests, and
+// records the requests.
+type mapServer struct {
+	mu       sync.Mutex
+	maps     map[string]string
+	etag     string
+	modified time.Time
+	requests []*gohttp.Request
+	check    func(*gohttp.Request) bool
+}
+
+func (m *mapServer) ServeHTTP(w gohttp.ResponseWriter, r *gohttp.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, r)
+
+	if m.check != nil && !m.check(r) {
+		w.WriteHeader(gohttp.StatusUnauthorized)
+		return
+	}
+	body, ok := m.maps[r.URL.Path]
+	if !ok {
+		w.WriteHeader(gohttp.StatusNotFound)
+		return
+	}
+	if m.etag != "" {
+		w.Header().Set("ETag", m.etag)
+		if r.Header.Get("If-None-Match") == m.etag {
+			w.WriteHeader(gohttp.StatusNotModified)
+			return
+		}
+	}
+	if !m.modified.IsZero() {
+		gohttp.ServeContent(w, r, "", m.modified, bytes.NewReader([]byte(body)))
+		return
+	}
+	fmt.Fprint(w, body)
+}
+
+func (m *mapServer) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.requests)
+}
+
+func (m *mapServer) last() *gohttp.Request {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests[len(m.requests)-1]
+}
+
+func TestSource_BearerTokenETag(t *testing.T) {
+	m := &mapServer{
+		maps: map[string]string{
+			"/nss/passwd.json": `[{"name": "foo", "passwd": "x", "uid": 1000, "gid": 1000, "dir": "/home/foo", "shell": "/bin/bash"}]`,
+			"/nss/shadow.json": `[{"name": "foo", "passwd": "*"}]`,
+			"/groups":          `[{"name": "foo", "gid": 1000, "mem": ["foo"]}]`,
+		},
+		etag: `"v1"`,
+		check: func(r *gohttp.Request) bool {
+			return r.Header.Get("Authorization") == "Bearer my-token"
+		},
+	}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	src, err := NewSource(URL(ts.URL+"/nss/{map}.json"), MapURL("group", ts.URL+"/groups"), BearerToken("my-token"))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "foo:x:1000:1000::/home/foo:/bin/bash\n", sourcetest.Fill(t, src.FillPasswdCache))
+	assert.Equal(t, "foo:*:::::::\n", sourcetest.Fill(t, src.FillShadowCache))
+	assert.Equal(t, "foo:x:1000:foo\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.False(t, src.Unchanged("group"))
+	assert.Equal(t, "", m.last().Header.Get("If-None-Match"))
+
+	assert.Equal(t, "foo:x:1000:foo\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.True(t, src.Unchanged("group"))
+	assert.Equal(t, `"v1"`, m.last().Header.Get("If-None-Match"))
+
+	m.mu.Lock()
+	m.maps["/groups"] = `[{"name": "bar", "gid": 1001}]`
+	m.etag = `"v2"`
+	m.mu.Unlock()
+	assert.Equal(t, "bar:x:1001:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.False(t, src.Unchanged("group"))
+}
+
+func TestSource_BasicAuthLastModified(t *testing.T) {
+	m := &mapServer{
+		maps:     map[string]string{"/group": `[{"name": "foo", "gid": 1000}]`},
+		modified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		check: func(r *gohttp.Request) bool {
+			u, p, ok := r.BasicAuth()
+			return ok && u == "user" && p == "pass"
+		},
+	}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	src, err := NewSource(URL(ts.URL+"/{map}"), BasicAuth("user", "pass"))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.Equal(t, "foo:x:1000:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.True(t, src.Unchanged("group"))
+	assert.Equal(t, "Wed, 01 Jan 2020 00:00:00 GMT", m.last().Header.Get("If-Modified-Since"))
+
+	src, err = NewSource(URL(ts.URL+"/{map}"), BasicAuth("user", "wrong"))
+	assert.Nil(t, err)
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unexpected status 401 Unauthorized")
+	}
+}
+
+func TestSource_StateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	m := &mapServer{
+		maps: map[string]string{"/group": `[{"name": "foo", "gid": 1000}]`},
+		etag: `"v1"`,
+	}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	src, err := NewSource(URL(ts.URL+"/{map}"), StateDir(dir))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.False(t, src.Unchanged("group"))
+
+	// A new source reuses the response kept in the state directory
+	src, err = NewSource(URL(ts.URL+"/{map}"), StateDir(dir))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.True(t, src.Unchanged("group"))
+	assert.Equal(t, `"v1"`, m.last().Header.Get("If-None-Match"))
+
+	// Corrupted state
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, stateFile), []byte("{"), 0644))
+	src, err = NewSource(URL(ts.URL+"/{map}"), StateDir(dir))
+	assert.Nil(t, err)
+	assert.NotNil(t, src.FillGroupCache(cache.NewCache()))
+}
+
+// writeCertificate generates a certificate signed by the parent, or
+// self-signed, and writes it with its key as PEM files in dir.
+func writeCertificate(t *testing.T, dir, name string, template *x509.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600))
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	assert.Nil(t, err)
+	return cert
+}
+
+func TestSource_TLS(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	client := writeCertificate(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(client.Leaf)
+
+	m := &mapServer{
+		maps: map[string]string{"/group": `[{"name": "foo", "gid": 1000}]`},
+	}
+	ts := httptest.NewUnstartedServer(m)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "ca.pem"), ca, 0600))
+
+	src, err := NewSource(
+		URL(ts.URL+"/{map}"),
+		ClientCertificate(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")),
+		CABundle(filepath.Join(dir, "ca.pem")),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", sourcetest.Fill(t, src.FillGroupCache))
+
+	// Without the client certificate
+	src, err = NewSource(URL(ts.URL+"/{map}"), CABundle(filepath.Join(dir, "ca.pem")))
+	assert.Nil(t, err)
+	assert.NotNil(t, src.FillGroupCache(cache.NewCache()))
+
+	// Without the CA bundle
+	src, err = NewSource(URL(ts.URL + "/{map}"))
+	assert.Nil(t, err)
+	assert.NotNil(t, src.FillGroupCache(cache.NewCache()))
+
+	_, err = NewSource(URL(ts.URL+"/{map}"), CABundle(filepath.Join(dir, "client.key")))
+	assert.NotNil(t, err)
+	_, err = NewSource(URL(ts.URL+"/{map}"), ClientCertificate("/does/not/exist", "/does/not/exist"))
+	assert.NotNil(t, err)
+}
+
+func TestSource_Errors(t *testing.T) {
+	m := &mapServer{
+		maps: map[string]string{
+			"/passwd": `{"name": "foo"}`,
+			"/group":  `[{"name": "foo", "gid": "1000"}]`,
+		},
+	}
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	src, err := NewSource(MapURL("passwd", ts.URL+"/passwd"), MapURL("group", ts.URL+"/group"))
+	assert.Nil(t, err)
+
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "json decoding: expected an array of entries", err.Error())
+	}
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "json does not match entry format at index 0: json: cannot unmarshal string into Go struct field GroupEntry.gid of type uint32", err.Error())
+	}
+	err = src.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "no URL configured for shadow", err.Error())
+	}
+
+	src, err = NewSource(URL(ts.URL + "/nss/{map}"))
+	assert.Nil(t, err)
+	err = src.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unexpected status 404 Not Found")
+	}
+	assert.Equal(t, 3, m.count())
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	gohttp "net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/MiLk/nsscache-go/source"
+	"github.com/pkg/errors"
+)
+
+// stateFile is the name of the file, within the state directory,
+// holding the ETag and Last-Modified of the responses kept.
+const stateFile = "state.json"
+
+// response is the last response received from a URL.
+type response struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	body         []byte
+}
+
+// fetch returns the body of the response from the URL, and true if it
+// is the previous response because the content was not modified.
+func (s *Source) fetch(u string) ([]byte, bool, error) {
+	previous, err := s.previous(u)
+	if err != nil {
+		return nil, false, err
+	}
+
+	req, err := gohttp.NewRequest(gohttp.MethodGet, u, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if s.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.bearerToken)
+	} else if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	if previous != nil {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == gohttp.StatusNotModified && previous != nil:
+		return previous.body, true, nil
+	case resp.StatusCode != gohttp.StatusOK:
+		return nil, false, errors.Errorf("unexpected status %s from %s", resp.Status, u)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	err = s.save(u, &response{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		body:         body,
+	})
+	return body, false, err
+}
+
+// previous returns the last response received from the URL, read from
+// the state directory if it is not in memory, or nil.
+func (s *Source) previous(u string) (*response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.responses[u]; ok || s.stateDir == "" {
+		return r, nil
+	}
+
+	state, err := s.loadState()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := state[u]
+	if !ok {
+		return nil, nil
+	}
+	body, err := ioutil.ReadFile(s.copyPath(u))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.body = body
+	s.responses[u] = r
+	return r, nil
+}
+
+// save keeps the response in memory and, if configured, in the state
+// directory.
+func (s *Source) save(u string, r *response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.responses[u] = r
+	if s.stateDir == "" {
+		return nil
+	}
+
+	if err := source.ReplaceFile(s.copyPath(u), bytes.NewReader(r.body)); err != nil {
+		return err
+	}
+	state, err := s.loadState()
+	if err != nil {
+		return err
+	}
+	state[u] = r
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return source.ReplaceFile(filepath.Join(s.stateDir, stateFile), bytes.NewReader(b))
+}
+
+// loadState reads the state file from the state directory.  A missing
+// state file results in an empty state.
+func (s *Source) loadState() (map[string]*response, error) {
+	state := map[string]*response{}
+	b, err := ioutil.ReadFile(filepath.Join(s.stateDir, stateFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, errors.Wrap(err, "json decoding state")
+	}
+	return state, nil
+}
+
+// copyPath returns the path of the copy of the last response from the
+// URL.
+func (s *Source) copyPath(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return filepath.Join(s.stateDir, hex.EncodeToString(sum[:]))
+}
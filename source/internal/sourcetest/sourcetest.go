@@ -0,0 +1,24 @@
+// Package sourcetest provides utilities for testing the source
+// implementations.
+package sourcetest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+// Fill fills a new cache with f, e.g. the FillPasswdCache method of a
+// source, and returns the content of the cache as it is written to
+// its file.  The test fails if filling or writing the cache fails.
+func Fill(t testing.TB, f func(*cache.Cache) error) string {
+	t.Helper()
+	c := cache.NewCache()
+	assert.Nil(t, f(c))
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	return b.String()
+}
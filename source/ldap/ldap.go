@@ -0,0 +1,428 @@
+// Package ldap implements a source.Source reading the users and groups
+// of an LDAP directory using the RFC2307 or RFC2307bis schemas.
+package ldap
+
+import (
+	"crypto/tls"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MiLk/nsscache-go/cache"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+)
+
+// DefaultPageSize is the number of entries requested per page of
+// search results.
+const DefaultPageSize = 500
+
+/*
+Source describes a source.Source for LDAP directories:
+  - url: the URL of the LDAP server, e.g. `ldaps://ldap.example.com`
+  - tlsConfig: the TLS configuration for ldaps and StartTLS
+  - startTLS: whether to upgrade the connection with StartTLS
+  - bindDN: the DN to bind as, anonymous if empty
+  - bindPassword: the password of the bind DN
+  - client: the connection to use instead of dialing the URL
+  - baseDNs: the base DNs searched, by map name or for all maps
+  - filters: the search filters, by map name
+  - attributes: the mapping from entry fields to attributes, by map name
+  - pageSize: the number of entries per page, no paging if 0
+  - rfc2307bis: whether groups list their members by DN
+*/
+type Source struct {
+	url          string
+	tlsConfig    *tls.Config
+	startTLS     bool
+	bindDN       string
+	bindPassword string
+	client       goldap.Client
+	baseDNs      map[string][]string
+	filters      map[string]string
+	attributes   map[string]map[string]string
+	pageSize     uint32
+	rfc2307bis   bool
+}
+
+// Option represents a function which will make some change to the
+// source during initialization.
+type Option func(*Source)
+
+// URL is an option function which will set the URL of the LDAP server,
+// e.g. `ldap://ldap.example.com:389` or `ldaps://ldap.example.com`.
+func URL(u string) Option {
+	return func(s *Source) { s.url = u }
+}
+
+// TLSConfig is an option function which will set the TLS configuration
+// used for ldaps URLs and StartTLS.
+func TLSConfig(cfg *tls.Config) Option {
+	return func(s *Source) { s.tlsConfig = cfg }
+}
+
+// StartTLS is an option function which will make the source upgrade
+// the connection with StartTLS before binding.
+func StartTLS() Option {
+	return func(s *Source) { s.startTLS = true }
+}
+
+// Bind is an option function which will make the source bind with the
+// provided DN and password.  The source binds anonymously by default.
+func Bind(dn, password string) Option {
+	return func(s *Source) {
+		s.bindDN = dn
+		s.bindPassword = password
+	}
+}
+
+// Client is an option function which will make the source use the
+// provided connection, already bound, instead of dialing the URL.
+func Client(c goldap.Client) Option {
+	return func(s *Source) { s.client = c }
+}
+
+// BaseDN is an option function which will set the base DNs searched
+// for the entries of all the maps.
+func BaseDN(dns ...string) Option {
+	return func(s *Source) { s.baseDNs[""] = dns }
+}
+
+// MapBaseDN is an option function which will set the base DNs searched
+// for the entries of the named map, e.g. `group`, overriding BaseDN.
+func MapBaseDN(name string, dns ...string) Option {
+	return func(s *Source) { s.baseDNs[name] = dns }
+}
+
+// Filter is an option function which will set the search filter of the
+// named map.  The defaults are `(objectClass=posixAccount)` for passwd,
+// `(objectClass=shadowAccount)` for shadow and `(objectClass=posixGroup)`
+// for group.
+func Filter(name, filter string) Option {
+	return func(s *Source) { s.filters[name] = filter }
+}
+
+// Attribute is an option function which will set the attribute read
+// for a field of the entries of the named map.  The fields are named
+// after the JSON fields of the entries, e.g. `gecos` in the passwd map
+// is read from the `gecos` attribute by default, and `member` in the
+// group map holds the member DNs with RFC2307bis.  Fields mapped to an
+// empty attribute are not read.
+func Attribute(name, field, attribute string) Option {
+	return func(s *Source) {
+		attrs := map[string]string{}
+		for k, v := range s.attributes[name] {
+			attrs[k] = v
+		}
+		attrs[field] = attribute
+		s.attributes[name] = attrs
+	}
+}
+
+// PageSize is an option function which will set the number of entries
+// requested per page of search results.  Paging is disabled if 0.
+// Defaults to DefaultPageSize.
+func PageSize(n uint32) Option {
+	return func(s *Source) { s.pageSize = n }
+}
+
+// RFC2307bis is an option function which will make the source read the
+// members of the groups from their `member` DNs, as in the RFC2307bis
+// schema, in addition to their `memberUid` values.  Members which are
+// groups themselves are expanded.
+func RFC2307bis() Option {
+	return func(s *Source) { s.rfc2307bis = true }
+}
+
+// NewSource creates a new LDAP source using the options provided.
+func NewSource(opts ...Option) (*Source, error) {
+	s := Source{
+		baseDNs: map[string][]string{},
+		filters: map[string]string{
+			"passwd": "(objectClass=posixAccount)",
+			"shadow": "(objectClass=shadowAccount)",
+			"group":  "(objectClass=posixGroup)",
+		},
+		attributes: map[string]map[string]string{
+			"passwd": {
+				"name":  "uid",
+				"uid":   "uidNumber",
+				"gid":   "gidNumber",
+				"gecos": "gecos",
+				"dir":   "homeDirectory",
+				"shell": "loginShell",
+			},
+			"shadow": {
+				"name":   "uid",
+				"passwd": "userPassword",
+				"lstchg": "shadowLastChange",
+				"min":    "shadowMin",
+				"max":    "shadowMax",
+				"warn":   "shadowWarning",
+				"inact":  "shadowInactive",
+				"expire": "shadowExpire",
+				"flag":   "shadowFlag",
+			},
+			"group": {
+				"name":   "cn",
+				"gid":    "gidNumber",
+				"mem":    "memberUid",
+				"member": "member",
+			},
+		},
+		pageSize: DefaultPageSize,
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	if s.client == nil && s.url == "" {
+		return nil, errors.New("no LDAP URL or client configured")
+	}
+
+	return &s, nil
+}
+
+// connect returns a bound connection to the server, and the function
+// releasing it.
+func (s *Source) connect() (goldap.Client, func(), error) {
+	if s.client != nil {
+		return s.client, func() {}, nil
+	}
+
+	conn, err := goldap.DialURL(s.url, goldap.DialWithTLSConfig(s.tlsConfig))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "connecting to LDAP")
+	}
+	if s.startTLS {
+		if err := conn.StartTLS(s.tlsConfig); err != nil {
+			conn.Close()
+			return nil, nil, errors.Wrap(err, "starting TLS")
+		}
+	}
+	if s.bindDN != "" {
+		if err := conn.Bind(s.bindDN, s.bindPassword); err != nil {
+			conn.Close()
+			return nil, nil, errors.Wrap(err, "binding to LDAP")
+		}
+	}
+	return conn, func() { conn.Close() }, nil
+}
+
+// search returns the entries of the named map found under all its base
+// DNs, with the attributes of the fields read.
+func (s *Source) search(conn goldap.Client, name string) ([]*goldap.Entry, error) {
+	baseDNs, ok := s.baseDNs[name]
+	if !ok {
+		baseDNs = s.baseDNs[""]
+	}
+	if len(baseDNs) == 0 {
+		return nil, errors.Errorf("no base DN configured for %s", name)
+	}
+
+	var attrs []string
+	for field, attr := range s.attributes[name] {
+		if attr != "" && (field != "member" || s.rfc2307bis) {
+			attrs = append(attrs, attr)
+		}
+	}
+	sort.Strings(attrs)
+
+	var entries []*goldap.Entry
+	for _, base := range baseDNs {
+		req := goldap.NewSearchRequest(base, goldap.ScopeWholeSubtree, goldap.NeverDerefAliases,
+			0, 0, false, s.filters[name], attrs, nil)
+
+		var res *goldap.SearchResult
+		var err error
+		if s.pageSize > 0 {
+			res, err = conn.SearchWithPaging(req, s.pageSize)
+		} else {
+			res, err = conn.Search(req)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "searching %s", base)
+		}
+		entries = append(entries, res.Entries...)
+	}
+	return entries, nil
+}
+
+// fields reads the attributes of the fields of the named map.
+type fields struct {
+	attributes map[string]string
+	entry      *goldap.Entry
+}
+
+func (f fields) get(field string) string {
+	if attr := f.attributes[field]; attr != "" {
+		return f.entry.GetAttributeValue(attr)
+	}
+	return ""
+}
+
+func (f fields) values(field string) []string {
+	if attr := f.attributes[field]; attr != "" {
+		return f.entry.GetAttributeValues(attr)
+	}
+	return nil
+}
+
+func (f fields) uint32(field string) (uint32, error) {
+	v, err := strconv.ParseUint(f.get(field), 10, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s of %s", field, f.entry.DN)
+	}
+	return uint32(v), nil
+}
+
+func (f fields) int32(field string) (*int32, error) {
+	s := f.get(field)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s of %s", field, f.entry.DN)
+	}
+	r := int32(v)
+	return &r, nil
+}
+
+// complete returns true if the entry has all the required fields.
+func (f fields) complete(required ...string) bool {
+	for _, field := range required {
+		if f.get(field) == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Source) fill(name string, fill func(goldap.Client, []*goldap.Entry) error) error {
+	conn, release, err := s.connect()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entries, err := s.search(conn, name)
+	if err != nil {
+		return err
+	}
+	return fill(conn, entries)
+}
+
+// FillPasswdCache searches the posixAccount entries and fills the
+// passwd cache.  Entries without a name, UID or GID are skipped.
+func (s *Source) FillPasswdCache(c *cache.Cache) error {
+	return s.fill("passwd", func(_ goldap.Client, entries []*goldap.Entry) error {
+		for _, e := range entries {
+			f := fields{s.attributes["passwd"], e}
+			if !f.complete("name", "uid", "gid") {
+				continue
+			}
+			uid, err := f.uint32("uid")
+			if err != nil {
+				return err
+			}
+			gid, err := f.uint32("gid")
+			if err != nil {
+				return err
+			}
+			c.Add(&cache.PasswdEntry{
+				Name:   f.get("name"),
+				Passwd: f.get("passwd"),
+				UID:    uid,
+				GID:    gid,
+				GECOS:  f.get("gecos"),
+				Dir:    f.get("dir"),
+				Shell:  f.get("shell"),
+			})
+		}
+		return nil
+	})
+}
+
+// FillShadowCache searches the shadowAccount entries and fills the
+// shadow cache.  Only the passwords in the crypt format are kept, the
+// other passwords are replaced by `*`.  Entries without a name are
+// skipped.
+func (s *Source) FillShadowCache(c *cache.Cache) error {
+	return s.fill("shadow", func(_ goldap.Client, entries []*goldap.Entry) error {
+		for _, e := range entries {
+			f := fields{s.attributes["shadow"], e}
+			if !f.complete("name") {
+				continue
+			}
+			entry := cache.ShadowEntry{
+				Name:   f.get("name"),
+				Passwd: cryptPassword(f.get("passwd")),
+			}
+			for field, set := range map[string]func(int32){
+				"lstchg": func(v int32) { entry.Lstchg = cache.Int32(v) },
+				"min":    func(v int32) { entry.Min = cache.Int32(v) },
+				"max":    func(v int32) { entry.Max = cache.Int32(v) },
+				"warn":   func(v int32) { entry.Warn = cache.Int32(v) },
+				"inact":  func(v int32) { entry.Inact = cache.Int32(v) },
+				"expire": func(v int32) { entry.Expire = cache.Int32(v) },
+			} {
+				v, err := f.int32(field)
+				if err != nil {
+					return err
+				}
+				if v != nil {
+					set(*v)
+				}
+			}
+			if f.get("flag") != "" {
+				v, err := f.uint32("flag")
+				if err != nil {
+					return err
+				}
+				entry.Flag = cache.UInt32(v)
+			}
+			c.Add(&entry)
+		}
+		return nil
+	})
+}
+
+// cryptPassword returns the hash of a userPassword in the crypt
+// format, or `*`.
+func cryptPassword(p string) string {
+	if len(p) > 7 && strings.EqualFold(p[:7], "{crypt}") {
+		return p[7:]
+	}
+	return "*"
+}
+
+// FillGroupCache searches the posixGroup entries and fills the group
+// cache.  Entries without a name or GID are skipped.
+func (s *Source) FillGroupCache(c *cache.Cache) error {
+	return s.fill("group", func(conn goldap.Client, entries []*goldap.Entry) error {
+		r := newResolver(s, conn, entries)
+		for _, e := range entries {
+			f := fields{s.attributes["group"], e}
+			if !f.complete("name", "gid") {
+				continue
+			}
+			gid, err := f.uint32("gid")
+			if err != nil {
+				return err
+			}
+			mem, err := r.members(e)
+			if err != nil {
+				return err
+			}
+			c.Add(&cache.GroupEntry{
+				Name:   f.get("name"),
+				Passwd: f.get("passwd"),
+				GID:    gid,
+				Mem:    mem,
+			})
+		}
+		return nil
+	})
+}
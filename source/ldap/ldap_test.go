@@ -0,0 +1,183 @@
+package ldap
+
+import (
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEntries() []*goldap.Entry {
+	return []*goldap.Entry{
+		goldap.NewEntry("ou=people,dc=example,dc=com", map[string][]string{
+			"objectClass": {"organizationalUnit"},
+		}),
+		goldap.NewEntry("uid=foo,ou=people,dc=example,dc=com", map[string][]string{
+			"objectClass":      {"posixAccount", "shadowAccount"},
+			"uid":              {"foo"},
+			"cn":               {"Foo"},
+			"uidNumber":        {"1000"},
+			"gidNumber":        {"1000"},
+			"gecos":            {"Foo Bar"},
+			"homeDirectory":    {"/home/foo"},
+			"loginShell":       {"/bin/bash"},
+			"userPassword":     {"{CRYPT}$6$hash"},
+			"shadowLastChange": {"18000"},
+			"shadowMax":        {"99999"},
+		}),
+		goldap.NewEntry("uid=bar,ou=people,dc=example,dc=com", map[string][]string{
+			"objectClass":   {"posixAccount", "shadowAccount"},
+			"uid":           {"bar"},
+			"cn":            {"Bar"},
+			"uidNumber":     {"1001"},
+			"gidNumber":     {"1000"},
+			"homeDirectory": {"/home/bar"},
+			"loginShell":    {"/bin/zsh"},
+			"userPassword":  {"{SSHA}c2FsdGVk"},
+		}),
+		goldap.NewEntry("uid=incomplete,ou=people,dc=example,dc=com", map[string][]string{
+			"objectClass": {"posixAccount"},
+			"uid":         {"incomplete"},
+		}),
+		goldap.NewEntry("cn=Baz Qux,ou=contractors,dc=example,dc=com", map[string][]string{
+			"objectClass":   {"posixAccount"},
+			"uid":           {"baz"},
+			"cn":            {"Baz Qux"},
+			"uidNumber":     {"2000"},
+			"gidNumber":     {"2000"},
+			"homeDirectory": {"/home/baz"},
+			"loginShell":    {"/bin/sh"},
+		}),
+		goldap.NewEntry("cn=users,ou=groups,dc=example,dc=com", map[string][]string{
+			"objectClass": {"posixGroup", "groupOfNames"},
+			"cn":          {"users"},
+			"gidNumber":   {"1000"},
+			"memberUid":   {"foo"},
+			"member": {
+				"uid=bar,ou=people,dc=example,dc=com",
+				"cn=Baz Qux,ou=contractors,dc=example,dc=com",
+				"cn=admins,ou=groups,dc=example,dc=com",
+				"cn=gone,ou=people,dc=example,dc=com",
+			},
+		}),
+		goldap.NewEntry("cn=admins,ou=groups,dc=example,dc=com", map[string][]string{
+			"objectClass": {"posixGroup", "groupOfNames"},
+			"cn":          {"admins"},
+			"gidNumber":   {"1001"},
+			"member": {
+				"UID=foo, OU=people, DC=example, DC=com",
+				"cn=users,ou=groups,dc=example,dc=com",
+				"uid=root,ou=system,dc=example,dc=com",
+			},
+		}),
+	}
+}
+
+func TestSource_RFC2307(t *testing.T) {
+	srv := newServer(t, testEntries())
+	defer srv.Close()
+	srv.credentials["cn=reader,dc=example,dc=com"] = "secret"
+
+	src, err := NewSource(
+		URL(srv.URL()),
+		Bind("cn=reader,dc=example,dc=com", "secret"),
+		BaseDN("ou=people,dc=example,dc=com", "ou=contractors,dc=example,dc=com"),
+		MapBaseDN("group", "ou=groups,dc=example,dc=com"),
+	)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "foo:x:1000:1000:Foo Bar:/home/foo:/bin/bash\n"+
+		"bar:x:1001:1000::/home/bar:/bin/zsh\n"+
+		"baz:x:2000:2000::/home/baz:/bin/sh\n", sourcetest.Fill(t, src.FillPasswdCache))
+	assert.Equal(t, "foo:$6$hash:18000::99999::::\n"+
+		"bar:*:::::::\n", sourcetest.Fill(t, src.FillShadowCache))
+	assert.Equal(t, "users:x:1000:foo\n"+
+		"admins:x:1001:\n", sourcetest.Fill(t, src.FillGroupCache))
+}
+
+func TestSource_RFC2307bis(t *testing.T) {
+	srv := newServer(t, testEntries())
+	defer srv.Close()
+
+	src, err := NewSource(URL(srv.URL()), BaseDN("dc=example,dc=com"), RFC2307bis())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "users:x:1000:foo,bar,baz,root\n"+
+		"admins:x:1001:foo,bar,baz,root\n", sourcetest.Fill(t, src.FillGroupCache))
+}
+
+func TestSource_Options(t *testing.T) {
+	srv := newServer(t, testEntries())
+	defer srv.Close()
+
+	src, err := NewSource(
+		URL(srv.URL()),
+		BaseDN("dc=example,dc=com"),
+		Filter("passwd", "(&(objectClass=posixAccount)(!(uid=bar)))"),
+		Attribute("passwd", "gecos", "cn"),
+		Attribute("passwd", "shell", ""),
+		PageSize(2),
+	)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "foo:x:1000:1000:Foo:/home/foo:\n"+
+		"baz:x:2000:2000:Baz Qux:/home/baz:\n", sourcetest.Fill(t, src.FillPasswdCache))
+	assert.Equal(t, 2, srv.pageCount())
+
+	src, err = NewSource(URL(srv.URL()), BaseDN("dc=example,dc=com"), PageSize(0))
+	assert.Nil(t, err)
+	sourcetest.Fill(t, src.FillPasswdCache)
+	assert.Equal(t, 3, srv.pageCount())
+}
+
+func TestSource_Client(t *testing.T) {
+	srv := newServer(t, testEntries())
+	defer srv.Close()
+
+	conn, err := goldap.DialURL(srv.URL())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	src, err := NewSource(Client(conn), BaseDN("ou=groups,dc=example,dc=com"))
+	assert.Nil(t, err)
+	assert.Equal(t, "users:x:1000:foo\n"+
+		"admins:x:1001:\n", sourcetest.Fill(t, src.FillGroupCache))
+	assert.Equal(t, "users:x:1000:foo\n"+
+		"admins:x:1001:\n", sourcetest.Fill(t, src.FillGroupCache))
+}
+
+func TestSource_Errors(t *testing.T) {
+	_, err := NewSource()
+	assert.NotNil(t, err)
+
+	srv := newServer(t, append(testEntries(), goldap.NewEntry("uid=broken,ou=people,dc=example,dc=com", map[string][]string{
+		"objectClass": {"posixAccount"},
+		"uid":         {"broken"},
+		"uidNumber":   {"abc"},
+		"gidNumber":   {"1000"},
+	})))
+	defer srv.Close()
+
+	src, err := NewSource(URL(srv.URL()), Bind("cn=reader,dc=example,dc=com", "wrong"), BaseDN("dc=example,dc=com"))
+	assert.Nil(t, err)
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "binding to LDAP")
+	}
+
+	src, err = NewSource(URL(srv.URL()), MapBaseDN("group", "dc=example,dc=com"))
+	assert.Nil(t, err)
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "no base DN configured for passwd")
+	}
+
+	src, err = NewSource(URL(srv.URL()), BaseDN("dc=example,dc=com"))
+	assert.Nil(t, err)
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "invalid uid of uid=broken,ou=people,dc=example,dc=com")
+	}
+}
@@ -0,0 +1,132 @@
+package ldap
+
+import (
+	"strings"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+)
+
+// resolver lists the members of the groups, resolving the member DNs
+// of RFC2307bis groups to user names.
+type resolver struct {
+	s      *Source
+	conn   goldap.Client
+	groups map[string]*goldap.Entry
+	names  map[string]string
+}
+
+func newResolver(s *Source, conn goldap.Client, groups []*goldap.Entry) *resolver {
+	r := &resolver{
+		s:      s,
+		conn:   conn,
+		groups: map[string]*goldap.Entry{},
+		names:  map[string]string{},
+	}
+	for _, g := range groups {
+		r.groups[normalizeDN(g.DN)] = g
+	}
+	return r
+}
+
+// members returns the names of the members of the group, without
+// duplicates, in the order they are listed.
+func (r *resolver) members(group *goldap.Entry) ([]string, error) {
+	var mem []string
+	seen := map[string]bool{}
+	err := r.collect(group, map[string]bool{}, func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			mem = append(mem, name)
+		}
+	})
+	return mem, err
+}
+
+// collect calls add with the name of every member of the group,
+// expanding the groups it contains.
+func (r *resolver) collect(group *goldap.Entry, visited map[string]bool, add func(string)) error {
+	dn := normalizeDN(group.DN)
+	if visited[dn] {
+		return nil
+	}
+	visited[dn] = true
+
+	f := fields{r.s.attributes["group"], group}
+	for _, name := range f.values("mem") {
+		add(name)
+	}
+	if !r.s.rfc2307bis {
+		return nil
+	}
+
+	for _, member := range f.values("member") {
+		if g, ok := r.groups[normalizeDN(member)]; ok {
+			if err := r.collect(g, visited, add); err != nil {
+				return err
+			}
+			continue
+		}
+		name, err := r.name(member)
+		if err != nil {
+			return err
+		}
+		if name != "" {
+			add(name)
+		}
+	}
+	return nil
+}
+
+// name returns the user name of the entry with the given DN, read from
+// its RDN when it is the name attribute, or else from the entry
+// itself.  It returns an empty string if the entry does not exist.
+func (r *resolver) name(dn string) (string, error) {
+	attr := r.s.attributes["passwd"]["name"]
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid member %s", dn)
+	}
+	if len(parsed.RDNs) > 0 && len(parsed.RDNs[0].Attributes) == 1 {
+		if rdn := parsed.RDNs[0].Attributes[0]; strings.EqualFold(rdn.Type, attr) {
+			return rdn.Value, nil
+		}
+	}
+
+	key := normalizeDN(dn)
+	if name, ok := r.names[key]; ok {
+		return name, nil
+	}
+	res, err := r.conn.Search(goldap.NewSearchRequest(dn, goldap.ScopeBaseObject, goldap.NeverDerefAliases,
+		0, 0, false, "(objectClass=*)", []string{attr}, nil))
+	if goldap.IsErrorWithCode(err, goldap.LDAPResultNoSuchObject) {
+		r.names[key] = ""
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "reading member %s", dn)
+	}
+	var name string
+	if len(res.Entries) > 0 {
+		name = res.Entries[0].GetAttributeValue(attr)
+	}
+	r.names[key] = name
+	return name, nil
+}
+
+// normalizeDN returns a form of the DN suitable for comparisons.
+func normalizeDN(dn string) string {
+	parsed, err := goldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, a := range rdn.Attributes {
+			attrs = append(attrs, strings.ToLower(a.Type)+"="+strings.ToLower(a.Value))
+		}
+		rdns = append(rdns, strings.Join(attrs, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
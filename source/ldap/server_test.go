@@ -0,0 +1,264 @@
+package ldap
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	goldap "github.com/go-ldap/ldap/v3"
+)
+
+// server is an in-process stand-in for an LDAP server, answering
+// simple binds and searches, with the paged results control, from a
+// fixed set of entries.
+type server struct {
+	listener    net.Listener
+	entries     []*goldap.Entry
+	credentials map[string]string
+
+	mu    sync.Mutex
+	pages int
+}
+
+func newServer(t *testing.T, entries []*goldap.Entry) *server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{listener: l, entries: entries, credentials: map[string]string{}}
+	go s.serve()
+	return s
+}
+
+func (s *server) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *server) Close() {
+	s.listener.Close()
+}
+
+// pageCount returns the number of pages of search results served.
+func (s *server) pageCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pages
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case goldap.ApplicationBindRequest:
+			code := goldap.LDAPResultSuccess
+			name := op.Children[1].Value.(string)
+			if password, ok := s.credentials[name]; !ok || password != op.Children[2].Data.String() {
+				code = goldap.LDAPResultInvalidCredentials
+			}
+			responses = append(responses, envelope(id, result(goldap.ApplicationBindResponse, code), nil))
+		case goldap.ApplicationSearchRequest:
+			responses = s.search(id, packet)
+		case goldap.ApplicationUnbindRequest:
+			return
+		default:
+			responses = append(responses, envelope(id, result(goldap.ApplicationExtendedResponse, goldap.LDAPResultUnwillingToPerform), nil))
+		}
+
+		for _, r := range responses {
+			if _, err := conn.Write(r.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *server) search(id int64, packet *ber.Packet) []*ber.Packet {
+	op := packet.Children[1]
+	base := normalizeDN(op.Children[0].Value.(string))
+	scope := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+	var attrs []string
+	for _, a := range op.Children[7].Children {
+		attrs = append(attrs, a.Value.(string))
+	}
+
+	var paging *goldap.ControlPaging
+	if len(packet.Children) > 2 {
+		for _, c := range packet.Children[2].Children {
+			if ctrl, err := goldap.DecodeControl(c); err == nil {
+				if p, ok := ctrl.(*goldap.ControlPaging); ok {
+					paging = p
+				}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.pages++
+	s.mu.Unlock()
+
+	var found []*goldap.Entry
+	exists := false
+	for _, e := range s.entries {
+		dn := normalizeDN(e.DN)
+		if dn == base {
+			exists = true
+		}
+		if scope == int64(goldap.ScopeBaseObject) && dn != base {
+			continue
+		}
+		if dn != base && !strings.HasSuffix(dn, ","+base) {
+			continue
+		}
+		if match(e, filter) {
+			found = append(found, e)
+		}
+	}
+	if !exists && scope == int64(goldap.ScopeBaseObject) {
+		return []*ber.Packet{envelope(id, result(goldap.ApplicationSearchResultDone, goldap.LDAPResultNoSuchObject), nil)}
+	}
+
+	var controls []goldap.Control
+	if paging != nil {
+		offset, _ := strconv.Atoi(string(paging.Cookie))
+		end := offset + int(paging.PagingSize)
+		if end > len(found) {
+			end = len(found)
+		}
+		total := len(found)
+		found = found[offset:end]
+
+		next := goldap.NewControlPaging(0)
+		if end < total {
+			next.SetCookie([]byte(strconv.Itoa(end)))
+		}
+		controls = append(controls, next)
+	}
+
+	var responses []*ber.Packet
+	for _, e := range found {
+		responses = append(responses, envelope(id, entry(e, attrs), nil))
+	}
+	return append(responses, envelope(id, result(goldap.ApplicationSearchResultDone, goldap.LDAPResultSuccess), controls))
+}
+
+// match evaluates the and, or, not, equality and presence filters.
+func match(e *goldap.Entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case goldap.FilterAnd:
+		for _, f := range filter.Children {
+			if !match(e, f) {
+				return false
+			}
+		}
+		return true
+	case goldap.FilterOr:
+		for _, f := range filter.Children {
+			if match(e, f) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterNot:
+		return !match(e, filter.Children[0])
+	case goldap.FilterEqualityMatch:
+		attr := filter.Children[0].Value.(string)
+		value := filter.Children[1].Value.(string)
+		for _, v := range values(e, attr) {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case goldap.FilterPresent:
+		attr := filter.Data.String()
+		return strings.EqualFold(attr, "objectClass") || len(values(e, attr)) > 0
+	default:
+		return false
+	}
+}
+
+func values(e *goldap.Entry, attr string) []string {
+	for _, a := range e.Attributes {
+		if strings.EqualFold(a.Name, attr) {
+			return a.Values
+		}
+	}
+	return nil
+}
+
+func envelope(id int64, op *ber.Packet, controls []goldap.Control) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	p.AppendChild(op)
+	if len(controls) > 0 {
+		c := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+		for _, ctrl := range controls {
+			c.AppendChild(ctrl.Encode())
+		}
+		p.AppendChild(c)
+	}
+	return p
+}
+
+func result(tag ber.Tag, code int) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return p
+}
+
+func entry(e *goldap.Entry, attrs []string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, goldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, a := range e.Attributes {
+		if !requested(a.Name, attrs) {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "Type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range a.Values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(vals)
+		list.AppendChild(attr)
+	}
+	p.AppendChild(list)
+	return p
+}
+
+func requested(name string, attrs []string) bool {
+	if len(attrs) == 0 {
+		return true
+	}
+	for _, a := range attrs {
+		if a == "*" || strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package source
+
+import (
+	"fmt"
+
+	"github.com/MiLk/nsscache-go/cache"
+)
+
+// Conflict specifies how Merge resolves the entries of the same name,
+// or the same ID, provided by several sources.  The entries of the same
+// name or ID provided by a single source are not in conflict, and are
+// all kept.
+type Conflict int
+
+const (
+	// FirstWins keeps the entry of the source which comes first.
+	FirstWins Conflict = iota
+	// LastWins keeps the entry of the source which comes last.
+	LastWins
+	// Fail makes the fill fail.
+	Fail
+)
+
+// namedSource is a source of Merge.
+type namedSource struct {
+	name string
+	src  Source
+}
+
+/*
+Merge describes a Source combining the entries of an ordered list of
+sources:
+  - sources: the sources, in order
+  - optional: the sources which may fail
+  - precedence: the order of the sources of specific maps
+  - byName: the resolution of the conflicts of names
+  - byID: the resolution of the conflicts of UIDs and GIDs
+  - failures: the errors of the optional sources during the last fill,
+    by map name
+*/
+type Merge struct {
+	sources    []namedSource
+	optional   map[string]bool
+	precedence map[string][]string
+	byName     Conflict
+	byID       Conflict
+	failures   map[string][]error
+}
+
+// MergeOption represents a function which will make some change to the
+// merge during initialization.
+type MergeOption func(*Merge)
+
+// From is an option function which will add a source, identified by
+// name in the options and errors, after the sources already added.
+func From(name string, src Source) MergeOption {
+	return func(m *Merge) {
+		m.sources = append(m.sources, namedSource{name: name, src: src})
+	}
+}
+
+// Optional is an option function which will make the fill of a map
+// carry on without the entries of the named sources when they fail.
+// See Failures.
+func Optional(names ...string) MergeOption {
+	return func(m *Merge) {
+		for _, name := range names {
+			m.optional[name] = true
+		}
+	}
+}
+
+// Precedence is an option function which will set the named sources,
+// in order, the named map is filled from.  The sources not listed are
+// not used for this map.
+func Precedence(name string, sources ...string) MergeOption {
+	return func(m *Merge) { m.precedence[name] = sources }
+}
+
+// OnNameConflict is an option function which will set how the entries
+// of the same name from different sources are resolved.  Defaults to
+// FirstWins.
+func OnNameConflict(c Conflict) MergeOption {
+	return func(m *Merge) { m.byName = c }
+}
+
+// OnIDConflict is an option function which will set how the passwd
+// entries of the same UID, and the group entries of the same GID, from
+// different sources are resolved.  Defaults to FirstWins.
+func OnIDConflict(c Conflict) MergeOption {
+	return func(m *Merge) { m.byID = c }
+}
+
+// NewMerge creates a new Source merging the sources provided by the
+// options.
+func NewMerge(opts ...MergeOption) (*Merge, error) {
+	m := Merge{
+		optional:   map[string]bool{},
+		precedence: map[string][]string{},
+		failures:   map[string][]error{},
+	}
+
+	for _, opt := range opts {
+		opt(&m)
+	}
+
+	seen := map[string]bool{}
+	for _, s := range m.sources {
+		if seen[s.name] {
+			return nil, fmt.Errorf("duplicate source %s", s.name)
+		}
+		seen[s.name] = true
+	}
+	for name := range m.optional {
+		if !seen[name] {
+			return nil, fmt.Errorf("unknown optional source %s", name)
+		}
+	}
+
+	for name, sources := range m.precedence {
+		for _, s := range sources {
+			if _, ok := m.source(s); !ok {
+				return nil, fmt.Errorf("unknown source %s in the precedence of %s", s, name)
+			}
+		}
+	}
+
+	return &m, nil
+}
+
+func (m *Merge) source(name string) (namedSource, bool) {
+	for _, s := range m.sources {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return namedSource{}, false
+}
+
+// sourcesOf returns the sources of the named map, in order.
+func (m *Merge) sourcesOf(name string) []namedSource {
+	names, ok := m.precedence[name]
+	if !ok {
+		return m.sources
+	}
+	sources := make([]namedSource, 0, len(names))
+	for _, n := range names {
+		s, _ := m.source(n)
+		sources = append(sources, s)
+	}
+	return sources
+}
+
+// Failures returns the errors of the optional sources which failed
+// during the last fill of the named map.
+func (m *Merge) Failures(name string) []error {
+	return append([]error(nil), m.failures[name]...)
+}
+
+// merged holds the entries of a map while they are merged, indexed by
+// name and by ID.
+type merged struct {
+	m       *Merge
+	name    string
+	entries []cache.Entry
+	from    []string
+	names   map[string][]int
+	ids     map[string][]int
+}
+
+// add adds the entries of a source, resolving the conflicts with the
+// entries of the sources already added.  The entries of the same name
+// or ID within the source, such as the users sharing UID 0, are all
+// kept.
+func (r *merged) add(source string, entries []cache.Entry) error {
+	for _, e := range entries {
+		type conflict struct {
+			i    []int
+			c    Conflict
+			what string
+		}
+		var conflicts []conflict
+		if i := r.others(r.names[e.Column(0)], source); len(i) > 0 {
+			conflicts = append(conflicts, conflict{i, r.m.byName, "name " + e.Column(0)})
+		}
+		if i := r.others(r.ids[e.Column(2)], source); len(i) > 0 && e.Column(2) != "" {
+			conflicts = append(conflicts, conflict{i, r.m.byID, "id " + e.Column(2)})
+		}
+
+		keep := true
+		for _, c := range conflicts {
+			switch c.c {
+			case Fail:
+				return fmt.Errorf("conflicting %s in %s from %s and %s", c.what, r.name, r.from[c.i[0]], source)
+			case FirstWins:
+				keep = false
+			}
+		}
+		if !keep {
+			continue
+		}
+		for _, c := range conflicts {
+			for _, i := range c.i {
+				r.remove(i)
+			}
+		}
+
+		i := len(r.entries)
+		r.entries = append(r.entries, e)
+		r.from = append(r.from, source)
+		r.names[e.Column(0)] = append(r.names[e.Column(0)], i)
+		if id := e.Column(2); id != "" {
+			r.ids[id] = append(r.ids[id], i)
+		}
+	}
+	return nil
+}
+
+// others returns the indexes of the entries which do not come from the
+// given source.
+func (r *merged) others(indexes []int, source string) []int {
+	var others []int
+	for _, i := range indexes {
+		if r.from[i] != source {
+			others = append(others, i)
+		}
+	}
+	return others
+}
+
+// remove removes the entry at the given index.
+func (r *merged) remove(i int) {
+	e := r.entries[i]
+	if e == nil {
+		return
+	}
+	r.names[e.Column(0)] = without(r.names[e.Column(0)], i)
+	if id := e.Column(2); id != "" {
+		r.ids[id] = without(r.ids[id], i)
+	}
+	r.entries[i] = nil
+}
+
+// without returns the indexes other than i.
+func without(indexes []int, i int) []int {
+	kept := indexes[:0]
+	for _, j := range indexes {
+		if j != i {
+			kept = append(kept, j)
+		}
+	}
+	return kept
+}
+
+func (m *Merge) fill(name string, c *cache.Cache, fill func(Source, *cache.Cache) error) error {
+	m.failures[name] = nil
+
+	r := merged{m: m, name: name, names: map[string][]int{}, ids: map[string][]int{}}
+	for _, s := range m.sourcesOf(name) {
+		tmp := cache.NewCache()
+		if err := fill(s.src, tmp); err != nil {
+			err = fmt.Errorf("filling %s from %s: %w", name, s.name, err)
+			if !m.optional[s.name] {
+				return err
+			}
+			m.failures[name] = append(m.failures[name], err)
+			continue
+		}
+		if err := r.add(s.name, tmp.Entries()); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range r.entries {
+		if e != nil {
+			c.Add(e)
+		}
+	}
+	return nil
+}
+
+// FillPasswdCache fills the passwd cache with the merged entries of the
+// sources.
+func (m *Merge) FillPasswdCache(c *cache.Cache) error {
+	return m.fill("passwd", c, func(s Source, c *cache.Cache) error {
+		return s.FillPasswdCache(c)
+	})
+}
+
+// FillShadowCache fills the shadow cache with the merged entries of the
+// sources.
+func (m *Merge) FillShadowCache(c *cache.Cache) error {
+	return m.fill("shadow", c, func(s Source, c *cache.Cache) error {
+		return s.FillShadowCache(c)
+	})
+}
+
+// FillGroupCache fills the group cache with the merged entries of the
+// sources.
+func (m *Merge) FillGroupCache(c *cache.Cache) error {
+	return m.fill("group", c, func(s Source, c *cache.Cache) error {
+		return s.FillGroupCache(c)
+	})
+}
@@ -0,0 +1,167 @@
+package source
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// static is a Source filling the caches with fixed entries, or failing
+// with err.
+type static struct {
+	passwd []cache.Entry
+	shadow []cache.Entry
+	group  []cache.Entry
+	err    error
+}
+
+func (s *static) fill(c *cache.Cache, entries []cache.Entry) error {
+	if s.err != nil {
+		return s.err
+	}
+	c.Add(entries...)
+	return nil
+}
+
+func (s *static) FillPasswdCache(c *cache.Cache) error { return s.fill(c, s.passwd) }
+func (s *static) FillShadowCache(c *cache.Cache) error { return s.fill(c, s.shadow) }
+func (s *static) FillGroupCache(c *cache.Cache) error  { return s.fill(c, s.group) }
+
+func user(name string, uid uint32, shell string) *cache.PasswdEntry {
+	return &cache.PasswdEntry{Name: name, Passwd: "x", UID: uid, GID: uid, Dir: "/home/" + name, Shell: shell}
+}
+
+func testSources() (*static, *static, *static) {
+	files := &static{
+		passwd: []cache.Entry{user("root", 0, "/bin/bash"), user("breakglass", 999, "/bin/bash")},
+		shadow: []cache.Entry{&cache.ShadowEntry{Name: "breakglass", Passwd: "$6$local"}},
+		group:  []cache.Entry{&cache.GroupEntry{Name: "root", GID: 0}},
+	}
+	vault := &static{
+		passwd: []cache.Entry{user("foo", 1000, "/bin/zsh"), user("root", 1001, "/bin/sh")},
+		shadow: []cache.Entry{&cache.ShadowEntry{Name: "foo", Passwd: "*"}, &cache.ShadowEntry{Name: "breakglass", Passwd: "*"}},
+		group:  []cache.Entry{&cache.GroupEntry{Name: "foo", GID: 1000}},
+	}
+	s3 := &static{
+		passwd: []cache.Entry{user("svc", 1000, "/sbin/nologin"), user("bar", 2000, "/sbin/nologin")},
+		group:  []cache.Entry{&cache.GroupEntry{Name: "svc", GID: 2000, Mem: []string{"bar"}}},
+	}
+	return files, vault, s3
+}
+
+func TestMerge(t *testing.T) {
+	files, vault, s3 := testSources()
+
+	m, err := NewMerge(From("files", files), From("vault", vault), From("s3", s3))
+	assert.Nil(t, err)
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\nbreakglass:x:999:999::/home/breakglass:/bin/bash\nfoo:x:1000:1000::/home/foo:/bin/zsh\nbar:x:2000:2000::/home/bar:/sbin/nologin\n", sourcetest.Fill(t, m.FillPasswdCache))
+	assert.Equal(t, "breakglass:$6$local:::::::\nfoo:*:::::::\n", sourcetest.Fill(t, m.FillShadowCache))
+	assert.Equal(t, "root:x:0:\nfoo:x:1000:\nsvc:x:2000:bar\n", sourcetest.Fill(t, m.FillGroupCache))
+
+	m, err = NewMerge(From("files", files), From("vault", vault), From("s3", s3), OnNameConflict(LastWins), OnIDConflict(LastWins))
+	assert.Nil(t, err)
+	assert.Equal(t, "breakglass:x:999:999::/home/breakglass:/bin/bash\nroot:x:1001:1001::/home/root:/bin/sh\nsvc:x:1000:1000::/home/svc:/sbin/nologin\nbar:x:2000:2000::/home/bar:/sbin/nologin\n", sourcetest.Fill(t, m.FillPasswdCache))
+	assert.Equal(t, "foo:*:::::::\nbreakglass:*:::::::\n", sourcetest.Fill(t, m.FillShadowCache))
+
+	// The names and the IDs are resolved separately
+	m, err = NewMerge(From("files", files), From("vault", vault), From("s3", s3), OnIDConflict(Fail))
+	assert.Nil(t, err)
+	err = m.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "conflicting id 1000 in passwd from vault and s3", err.Error())
+	}
+	assert.Equal(t, "root:x:0:\nfoo:x:1000:\nsvc:x:2000:bar\n", sourcetest.Fill(t, m.FillGroupCache))
+
+	m, err = NewMerge(From("files", files), From("vault", vault), OnNameConflict(Fail))
+	assert.Nil(t, err)
+	err = m.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "conflicting name breakglass in shadow from files and vault", err.Error())
+	}
+}
+
+func TestMerge_SameSource(t *testing.T) {
+	files := &static{passwd: []cache.Entry{user("root", 0, "/bin/bash"), user("toor", 0, "/bin/sh")}}
+	vault := &static{passwd: []cache.Entry{user("admin", 0, "/bin/zsh")}}
+
+	// The entries of a single source are not in conflict
+	m, err := NewMerge(From("files", files), OnIDConflict(Fail))
+	assert.Nil(t, err)
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\ntoor:x:0:0::/home/toor:/bin/sh\n", sourcetest.Fill(t, m.FillPasswdCache))
+
+	m, err = NewMerge(From("files", files), From("vault", vault), OnIDConflict(Fail))
+	assert.Nil(t, err)
+	err = m.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "conflicting id 0 in passwd from files and vault", err.Error())
+	}
+
+	m, err = NewMerge(From("files", files), From("vault", vault))
+	assert.Nil(t, err)
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\ntoor:x:0:0::/home/toor:/bin/sh\n", sourcetest.Fill(t, m.FillPasswdCache))
+
+	m, err = NewMerge(From("vault", vault), From("files", files), OnIDConflict(LastWins))
+	assert.Nil(t, err)
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\ntoor:x:0:0::/home/toor:/bin/sh\n", sourcetest.Fill(t, m.FillPasswdCache))
+
+	m, err = NewMerge(From("files", files), From("vault", vault), OnIDConflict(LastWins))
+	assert.Nil(t, err)
+	assert.Equal(t, "admin:x:0:0::/home/admin:/bin/zsh\n", sourcetest.Fill(t, m.FillPasswdCache))
+}
+
+func TestMerge_Precedence(t *testing.T) {
+	files, vault, s3 := testSources()
+
+	m, err := NewMerge(
+		From("files", files), From("vault", vault), From("s3", s3),
+		Precedence("passwd", "s3", "files"),
+		Precedence("shadow", "vault"),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, "svc:x:1000:1000::/home/svc:/sbin/nologin\nbar:x:2000:2000::/home/bar:/sbin/nologin\nroot:x:0:0::/home/root:/bin/bash\nbreakglass:x:999:999::/home/breakglass:/bin/bash\n", sourcetest.Fill(t, m.FillPasswdCache))
+	assert.Equal(t, "foo:*:::::::\nbreakglass:*:::::::\n", sourcetest.Fill(t, m.FillShadowCache))
+	assert.Equal(t, "root:x:0:\nfoo:x:1000:\nsvc:x:2000:bar\n", sourcetest.Fill(t, m.FillGroupCache))
+
+	_, err = NewMerge(From("files", files), Precedence("passwd", "vault"))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "unknown source vault in the precedence of passwd", err.Error())
+	}
+	_, err = NewMerge(From("files", files), From("files", vault))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "duplicate source files", err.Error())
+	}
+	_, err = NewMerge(From("files", files), Optional("s3"))
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "unknown optional source s3", err.Error())
+	}
+}
+
+func TestMerge_Optional(t *testing.T) {
+	files, vault, s3 := testSources()
+	unavailable := errors.New("unavailable")
+	s3.err = unavailable
+
+	m, err := NewMerge(From("files", files), From("vault", vault), From("s3", s3))
+	assert.Nil(t, err)
+	err = m.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "filling passwd from s3: unavailable", err.Error())
+		assert.True(t, errors.Is(err, unavailable))
+	}
+
+	m, err = NewMerge(From("files", files), From("vault", vault), From("s3", s3), Optional("s3"))
+	assert.Nil(t, err)
+	assert.Equal(t, "root:x:0:0::/home/root:/bin/bash\nbreakglass:x:999:999::/home/breakglass:/bin/bash\nfoo:x:1000:1000::/home/foo:/bin/zsh\n", sourcetest.Fill(t, m.FillPasswdCache))
+	failures := m.Failures("passwd")
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "filling passwd from s3: unavailable", failures[0].Error())
+	}
+	assert.Empty(t, m.Failures("shadow"))
+
+	s3.err = nil
+	sourcetest.Fill(t, m.FillPasswdCache)
+	assert.Empty(t, m.Failures("passwd"))
+}
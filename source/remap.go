@@ -0,0 +1,59 @@
+package source
+
+import "github.com/MiLk/nsscache-go/cache"
+
+// Remap describes a Source wrapping another source, whose UIDs and
+// GIDs are remapped, e.g. to import from several directories whose ID
+// ranges overlap.  See cache.Cache.Remap.
+type Remap struct {
+	src Source
+	m   cache.IDMap
+}
+
+// NewRemap creates a new Source remapping the IDs of the entries of
+// the source.
+func NewRemap(src Source, m cache.IDMap) (*Remap, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return &Remap{src: src, m: m}, nil
+}
+
+// fill fills a temporary cache from the source, remaps it, and adds
+// its entries to the cache.  The remapped IDs must not collide with the
+// entries already in the cache.
+func (r *Remap) fill(c *cache.Cache, fill func(Source, *cache.Cache) error) error {
+	tmp := cache.NewCache()
+	if err := fill(r.src, tmp); err != nil {
+		return err
+	}
+	if err := tmp.Remap(r.m, c); err != nil {
+		return err
+	}
+	c.Add(tmp.Entries()...)
+	return nil
+}
+
+// FillPasswdCache fills the passwd cache with the remapped entries of
+// the source.
+func (r *Remap) FillPasswdCache(c *cache.Cache) error {
+	return r.fill(c, func(s Source, c *cache.Cache) error {
+		return s.FillPasswdCache(c)
+	})
+}
+
+// FillShadowCache fills the shadow cache with the entries of the
+// source, which have no IDs.
+func (r *Remap) FillShadowCache(c *cache.Cache) error {
+	return r.fill(c, func(s Source, c *cache.Cache) error {
+		return s.FillShadowCache(c)
+	})
+}
+
+// FillGroupCache fills the group cache with the remapped entries of
+// the source.
+func (r *Remap) FillGroupCache(c *cache.Cache) error {
+	return r.fill(c, func(s Source, c *cache.Cache) error {
+		return s.FillGroupCache(c)
+	})
+}
@@ -0,0 +1,35 @@
+package source
+
+import (
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemap(t *testing.T) {
+	_, vault, s3 := testSources()
+
+	remapped, err := NewRemap(s3, cache.IDMap{
+		UIDs: []cache.IDMapping{cache.Offset(1000, 9000, 100000)},
+		GIDs: []cache.IDMapping{cache.Offset(1000, 9000, 100000)},
+	})
+	assert.Nil(t, err)
+
+	m, err := NewMerge(From("vault", vault), From("s3", remapped), OnIDConflict(Fail))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:1000::/home/foo:/bin/zsh\nroot:x:1001:1001::/home/root:/bin/sh\nsvc:x:101000:101000::/home/svc:/sbin/nologin\nbar:x:102000:102000::/home/bar:/sbin/nologin\n", sourcetest.Fill(t, m.FillPasswdCache))
+	assert.Equal(t, "foo:x:1000:\nsvc:x:102000:bar\n", sourcetest.Fill(t, m.FillGroupCache))
+
+	// Collisions with the entries already in the cache
+	c := cache.NewCache()
+	c.Add(&cache.PasswdEntry{Name: "baz", UID: 102000})
+	err = remapped.FillPasswdCache(c)
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "uid 102000 of bar collides with baz", err.Error())
+	}
+
+	_, err = NewRemap(s3, cache.IDMap{UIDs: []cache.IDMapping{{From: 1000, To: 0, Size: 1}}})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,181 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source"
+	"github.com/MiLk/nsscache-go/source/files"
+)
+
+// Format describes the format of the payload of the objects.
+type Format int
+
+const (
+	// FormatJSON is a JSON array of entries.
+	FormatJSON Format = iota
+	// FormatJSONLines is one JSON entry per line.
+	FormatJSONLines
+	// FormatColonSeparated is the format of the /etc/passwd,
+	// /etc/shadow and /etc/group files.
+	FormatColonSeparated
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// trimCompression removes the compression extension, if any, from the
+// key and returns the encoding it designates.
+func trimCompression(key string) (string, string) {
+	switch path.Ext(key) {
+	case ".gz", ".gzip":
+		return strings.TrimSuffix(key, path.Ext(key)), "gzip"
+	case ".zst", ".zstd":
+		return strings.TrimSuffix(key, path.Ext(key)), "zstd"
+	default:
+		return key, ""
+	}
+}
+
+// objectFormat returns the format of the object with the given key,
+// detected from its extension, or the fallback format.
+func objectFormat(key string, fallback Format) Format {
+	key, _ = trimCompression(key)
+	switch path.Ext(key) {
+	case ".json":
+		return FormatJSON
+	case ".jsonl", ".ndjson":
+		return FormatJSONLines
+	default:
+		return fallback
+	}
+}
+
+// objectEncoding returns the compression of the object with the given
+// key and Content-Encoding.
+func objectEncoding(key, contentEncoding string) string {
+	switch strings.ToLower(contentEncoding) {
+	case "gzip", "x-gzip":
+		return "gzip"
+	case "zstd":
+		return "zstd"
+	}
+	_, encoding := trimCompression(key)
+	return encoding
+}
+
+// decompress returns a reader on the decompressed content of r.  The
+// content is only decompressed if it starts with the magic number of
+// the encoding, since HTTP clients may already have decoded it.
+func decompress(r io.Reader, encoding string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	switch encoding {
+	case "gzip":
+		if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+			return gzip.NewReader(br)
+		}
+	case "zstd":
+		if magic, _ := br.Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+			d, err := zstd.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		}
+	}
+	return io.NopCloser(br), nil
+}
+
+// decode reads the entries from r in the given format and adds them to
+// the cache.
+func decode(f Format, r io.Reader, c *cache.Cache, createEntry func() cache.Entry) error {
+	switch f {
+	case FormatJSONLines:
+		return decodeJSONLines(r, c, createEntry)
+	case FormatColonSeparated:
+		return decodeColonSeparated(r, c, createEntry)
+	default:
+		return source.DecodeEntries(r, c, createEntry)
+	}
+}
+
+// decodeJSONLines decodes the entries read from r, one JSON document
+// per line, and adds them to the cache.
+func decodeJSONLines(r io.Reader, c *cache.Cache, createEntry func() cache.Entry) error {
+	dec := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		e := createEntry()
+		err := dec.Decode(e)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return source.DecodingError(err, i)
+		}
+		c.Add(e)
+	}
+}
+
+// decodeColonSeparated parses the lines read from r in the format of
+// the /etc/passwd, /etc/shadow and /etc/group files and adds them to
+// the cache.
+func decodeColonSeparated(r io.Reader, c *cache.Cache, createEntry func() cache.Entry) error {
+	return files.Parse(r, c, createEntry)
+}
+
+// encode writes the entries of the cache to w in the given format.
+func encode(f Format, w io.Writer, c *cache.Cache) error {
+	switch f {
+	case FormatJSONLines:
+		enc := json.NewEncoder(w)
+		for _, e := range c.Sorted() {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	case FormatColonSeparated:
+		_, err := c.WriteTo(w)
+		return err
+	default:
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+}
+
+// compress returns a writer compressing what is written to w with the
+// given encoding.  The writer must be closed to flush the compressed
+// content.
+func compress(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	case "":
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, errors.Errorf("unsupported encoding %s", encoding)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
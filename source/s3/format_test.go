@@ -0,0 +1,164 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipString(t *testing.T, s string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	_, err := w.Write([]byte(s))
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+	return b.String()
+}
+
+func zstdString(t *testing.T, s string) string {
+	w, err := zstd.NewWriter(nil)
+	assert.Nil(t, err)
+	defer w.Close()
+	return string(w.EncodeAll([]byte(s), nil))
+}
+
+func TestObjectFormat(t *testing.T) {
+	assert.Equal(t, FormatJSON, objectFormat("nsscache/passwd", FormatJSON))
+	assert.Equal(t, FormatColonSeparated, objectFormat("nsscache/passwd", FormatColonSeparated))
+	assert.Equal(t, FormatJSON, objectFormat("nsscache/passwd.json", FormatColonSeparated))
+	assert.Equal(t, FormatJSON, objectFormat("nsscache/passwd.json.gz", FormatColonSeparated))
+	assert.Equal(t, FormatJSONLines, objectFormat("nsscache/passwd.jsonl", FormatJSON))
+	assert.Equal(t, FormatJSONLines, objectFormat("nsscache/passwd.ndjson.zst", FormatJSON))
+}
+
+func TestObjectEncoding(t *testing.T) {
+	assert.Equal(t, "", objectEncoding("nsscache/passwd", ""))
+	assert.Equal(t, "gzip", objectEncoding("nsscache/passwd", "gzip"))
+	assert.Equal(t, "gzip", objectEncoding("nsscache/passwd.json.gz", ""))
+	assert.Equal(t, "zstd", objectEncoding("nsscache/passwd.json.zst", ""))
+	assert.Equal(t, "zstd", objectEncoding("nsscache/passwd.json.gz", "zstd"))
+	assert.Equal(t, "gzip", objectEncoding("nsscache/passwd.json.gz", "identity"))
+}
+
+func TestSource_Compression(t *testing.T) {
+	body := `[{"name": "foo", "gid": 1000, "mem": ["bar"]}]`
+	for _, tc := range []struct {
+		name string
+		obj  *MockS3Object
+	}{
+		{"gzip", &MockS3Object{Body: gzipString(t, body), ContentEncoding: "gzip"}},
+		{"zstd", &MockS3Object{Body: zstdString(t, body), ContentEncoding: "zstd"}},
+		{"already decoded", &MockS3Object{Body: body, ContentEncoding: "gzip"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{"nsscache/group": tc.obj})
+			src, err := NewSource(svc, "testing-bucket", Prefix("nsscache"))
+			assert.Nil(t, err)
+
+			c := cache.NewCache()
+			assert.Nil(t, src.FillGroupCache(c))
+			var b bytes.Buffer
+			_, err = c.WriteTo(&b)
+			assert.Nil(t, err)
+			assert.Equal(t, "foo:x:1000:bar\n", b.String())
+		})
+	}
+}
+
+func TestSource_CompressionStateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"group": {
+			Body:            gzipString(t, `[{"name": "foo", "gid": 1000}]`),
+			ETag:            `"v1"`,
+			ContentEncoding: "gzip",
+		},
+	})
+	src, err := NewSource(svc, "testing-bucket", StateDir(dir))
+	assert.Nil(t, err)
+
+	for i := 0; i < 2; i++ {
+		c := cache.NewCache()
+		assert.Nil(t, src.FillGroupCache(c))
+		var b bytes.Buffer
+		_, err = c.WriteTo(&b)
+		assert.Nil(t, err)
+		assert.Equal(t, "foo:x:1000:\n", b.String())
+	}
+	assert.True(t, src.Unchanged("group"))
+}
+
+func TestSource_JSONLines(t *testing.T) {
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"passwd": {Body: `{"name": "foo", "passwd": "x", "uid": 1000, "gid": 1000, "dir": "/home/foo", "shell": "/bin/bash"}
+{"name": "bar", "passwd": "x", "uid": 1001, "gid": 1000, "dir": "/home/bar", "shell": "/bin/bash"}
+`},
+		"group": {Body: `{"name": "foo", "gid": 1000}
+{"name": "bar", "gid": "1001"}
+`},
+	})
+	src, err := NewSource(svc, "testing-bucket", ObjectFormat(FormatJSONLines))
+	assert.Nil(t, err)
+
+	c := cache.NewCache()
+	assert.Nil(t, src.FillPasswdCache(c))
+	var b bytes.Buffer
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:1000::/home/foo:/bin/bash\nbar:x:1001:1000::/home/bar:/bin/bash\n", b.String())
+
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "json does not match entry format at index 1")
+	}
+}
+
+func TestEncode_JSONLines(t *testing.T) {
+	c := cache.NewCache(cache.WithSort(cache.ByName))
+	c.Add(&cache.GroupEntry{Name: "foo", Passwd: "x", GID: 1000, Mem: []string{"foo"}},
+		&cache.GroupEntry{Name: "bar", Passwd: "x", GID: 1001})
+
+	var b bytes.Buffer
+	assert.Nil(t, encode(FormatJSONLines, &b, c))
+	assert.Equal(t, `{"name":"bar","passwd":"x","gid":1001,"mem":null}
+{"name":"foo","passwd":"x","gid":1000,"mem":["foo"]}
+`, b.String())
+}
+
+func TestSource_ColonSeparated(t *testing.T) {
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"passwd": {Body: "# users\nfoo:x:1000:1000:Foo:/home/foo:/bin/bash\n\nbar:x:1001:1000::/home/bar:/bin/bash"},
+		"shadow": {Body: "foo:$6$hash:18000:0:99999:7:::\n"},
+		"group":  {Body: "foo:x:1000:bar,baz\nbar:x:abc:\n"},
+	})
+	src, err := NewSource(svc, "testing-bucket", ObjectFormat(FormatColonSeparated))
+	assert.Nil(t, err)
+
+	c := cache.NewCache()
+	assert.Nil(t, src.FillPasswdCache(c))
+	var b bytes.Buffer
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:1000:Foo:/home/foo:/bin/bash\nbar:x:1001:1000::/home/bar:/bin/bash\n", b.String())
+
+	c = cache.NewCache()
+	assert.Nil(t, src.FillShadowCache(c))
+	b.Reset()
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:$6$hash:18000:0:99999:7:::\n", b.String())
+
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "parsing line 2")
+	}
+}
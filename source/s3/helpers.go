@@ -13,13 +13,12 @@ import (
 
 // DownloadS3Data returns the contents of a file (key argument) from the given bucket
 func DownloadS3Data(c s3iface.S3API, bucket string, key string) ([]byte, error) {
-	results, err := c.GetObject(&s3.GetObjectInput{
+	results, err := getObject(c, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
 	})
-
 	if err != nil {
-		return nil, errors.Wrap(err, fmt.Sprintf("error getting object %s from bucket %s", key, bucket))
+		return nil, err
 	}
 	defer results.Body.Close()
 
@@ -30,3 +29,13 @@ func DownloadS3Data(c s3iface.S3API, bucket string, key string) ([]byte, error)
 
 	return buf.Bytes(), nil
 }
+
+// getObject sends a GetObject request.  The caller is responsible for
+// closing the body of the response.
+func getObject(c s3iface.S3API, input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	results, err := c.GetObject(input)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("error getting object %s from bucket %s", aws.StringValue(input.Key), aws.StringValue(input.Bucket)))
+	}
+	return results, nil
+}
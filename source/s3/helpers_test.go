@@ -2,9 +2,14 @@ package s3
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"net/http"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/stretchr/testify/assert"
@@ -37,6 +42,76 @@ func CreateMockS3GetObjectClient(resp string, err error) *MockS3GetObject {
 	}
 }
 
+// MockS3Object is an object stored in a MockS3Objects client.
+type MockS3Object struct {
+	Body            string
+	ETag            string
+	LastModified    time.Time
+	ContentEncoding string
+}
+
+// MockS3Objects is a s3iface.S3API serving the objects it contains,
+// and answering conditional requests.  Specific versions of an object
+// are stored under `<key>?versionId=<version>`.  Every request is
+// recorded.  Objects put are stored as a new version.
+type MockS3Objects struct {
+	s3iface.S3API
+	objects  map[string]*MockS3Object
+	inputs   []*s3.GetObjectInput
+	puts     []string
+	versions int
+}
+
+func (m *MockS3Objects) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	m.inputs = append(m.inputs, input)
+	key := aws.StringValue(input.Key)
+	if input.VersionId != nil {
+		key += "?versionId=" + aws.StringValue(input.VersionId)
+	}
+	o, ok := m.objects[key]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil), http.StatusNotFound, "")
+	}
+	if input.IfNoneMatch != nil && o.ETag != "" && aws.StringValue(input.IfNoneMatch) == o.ETag {
+		return nil, awserr.NewRequestFailure(awserr.New("NotModified", "Not Modified", nil), http.StatusNotModified, "")
+	}
+	return &s3.GetObjectOutput{
+		Body:            ioutil.NopCloser(bytes.NewReader([]byte(o.Body))),
+		ETag:            aws.String(o.ETag),
+		LastModified:    aws.Time(o.LastModified),
+		ContentEncoding: aws.String(o.ContentEncoding),
+	}, nil
+}
+
+func (m *MockS3Objects) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	b, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.versions++
+	versionID := fmt.Sprint(m.versions)
+	o := &MockS3Object{
+		Body:            string(b),
+		ETag:            fmt.Sprintf(`"%s"`, versionID),
+		LastModified:    time.Now(),
+		ContentEncoding: aws.StringValue(input.ContentEncoding),
+	}
+	key := aws.StringValue(input.Key)
+	m.puts = append(m.puts, key)
+	m.objects[key] = o
+	m.objects[key+"?versionId="+versionID] = o
+	return &s3.PutObjectOutput{
+		ETag:      aws.String(o.ETag),
+		VersionId: aws.String(versionID),
+	}, nil
+}
+
+// CreateMockS3ObjectsClient returns a MockS3Objects client serving the
+// given objects by key.
+func CreateMockS3ObjectsClient(objects map[string]*MockS3Object) *MockS3Objects {
+	return &MockS3Objects{objects: objects}
+}
+
 func TestCreateSource(t *testing.T) {
 	svc := CreateMockS3GetObjectClient("", nil)
 	src := CreateSource(svc, "prefix", "bucket")
@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// defaultKeyTemplate is the template of the keys of the objects when
+// none is configured.
+const defaultKeyTemplate = "{prefix}/{map}"
+
+// candidates returns the keys of the objects which may hold the named
+// map, in the order they must be tried.
+func (s *Source) candidates(name string) []string {
+	templates, ok := s.mapKeys[name]
+	if !ok {
+		templates = s.templates
+	}
+
+	r := strings.NewReplacer("{prefix}", s.prefix, "{map}", name)
+	keys := make([]string, 0, len(templates))
+	for _, t := range templates {
+		keys = append(keys, strings.TrimPrefix(r.Replace(t), "/"))
+	}
+	return keys
+}
+
+// openFirst opens the first of the objects with the given keys which
+// exists, and returns its location.
+func (s *Source) openFirst(keys []string) (ManifestEntry, *object, error) {
+	if len(keys) == 0 {
+		return ManifestEntry{}, nil, errors.New("no key configured")
+	}
+
+	var err error
+	for _, key := range keys {
+		var obj *object
+		obj, err = s.open(key, "")
+		if err == nil {
+			return ManifestEntry{Key: key}, obj, nil
+		}
+		if !isNotFound(err) {
+			return ManifestEntry{}, nil, err
+		}
+	}
+	return ManifestEntry{}, nil, err
+}
+
+// isNotFound returns true if the error is the response of S3 to a
+// request for an object which does not exist.
+func isNotFound(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey
+}
@@ -0,0 +1,79 @@
+package s3
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource_Candidates(t *testing.T) {
+	src, err := NewSource(nil, "testing-bucket")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"passwd"}, src.candidates("passwd"))
+
+	src, err = NewSource(nil, "testing-bucket", Prefix("nss/v2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nss/v2/passwd"}, src.candidates("passwd"))
+
+	src, err = NewSource(nil, "testing-bucket", Prefix("nss/v2"),
+		KeyTemplate("{prefix}/{map}.json.gz", "{prefix}/{map}.json"),
+		MapKey("group", "shared/group", "{prefix}/{map}"),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"nss/v2/passwd.json.gz", "nss/v2/passwd.json"}, src.candidates("passwd"))
+	assert.Equal(t, []string{"shared/group", "nss/v2/group"}, src.candidates("group"))
+
+	src, err = NewSource(nil, "testing-bucket", KeyTemplate("{prefix}/{map}.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"passwd.json"}, src.candidates("passwd"))
+}
+
+func TestSource_KeyLayout(t *testing.T) {
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"nss/v2/passwd.json.gz": {Body: gzipString(t, `[{"name": "foo", "passwd": "x", "uid": 1000, "gid": 1000, "dir": "/home/foo", "shell": "/bin/bash"}]`)},
+		"nss/v2/shadow.jsonl":   {Body: `{"name": "foo", "passwd": "*"}` + "\n"},
+		"shared/group":          {Body: `[{"name": "foo", "gid": 1000}]`},
+	})
+	src, err := NewSource(svc, "testing-bucket", Prefix("nss/v2"),
+		KeyTemplate("{prefix}/{map}.json.gz", "{prefix}/{map}.jsonl"),
+		MapKey("group", "{prefix}/group", "shared/group"),
+	)
+	assert.Nil(t, err)
+
+	fill := func(f func(*cache.Cache) error) string {
+		c := cache.NewCache()
+		assert.Nil(t, f(c))
+		var b bytes.Buffer
+		_, err := c.WriteTo(&b)
+		assert.Nil(t, err)
+		return b.String()
+	}
+
+	assert.Equal(t, "foo:x:1000:1000::/home/foo:/bin/bash\n", fill(src.FillPasswdCache))
+	assert.Equal(t, "foo:*:::::::\n", fill(src.FillShadowCache))
+	assert.Equal(t, "foo:x:1000:\n", fill(src.FillGroupCache))
+
+	var keys []string
+	for _, input := range svc.inputs {
+		keys = append(keys, aws.StringValue(input.Key))
+	}
+	assert.Equal(t, []string{
+		"nss/v2/passwd.json.gz",
+		"nss/v2/shadow.json.gz", "nss/v2/shadow.jsonl",
+		"nss/v2/group", "shared/group",
+	}, keys)
+
+	src, err = NewSource(svc, "testing-bucket", MapKey("passwd", "missing", "also-missing"))
+	assert.Nil(t, err)
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "error getting object also-missing from bucket testing-bucket")
+	}
+
+	src, err = NewSource(svc, "testing-bucket", MapKey("passwd"))
+	assert.Nil(t, err)
+	assert.NotNil(t, src.FillPasswdCache(cache.NewCache()))
+}
@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// Manifest lists the objects holding the maps of a consistent
+// snapshot, by map name.
+type Manifest struct {
+	Maps map[string]ManifestEntry `json:"maps"`
+}
+
+// ManifestEntry locates the object holding a map.  When the bucket is
+// versioned, the versions of the object and of its signature pin the
+// exact content of the snapshot.
+type ManifestEntry struct {
+	Key                string `json:"key"`
+	VersionID          string `json:"version_id,omitempty"`
+	SignatureVersionID string `json:"signature_version_id,omitempty"`
+}
+
+// LoadManifest reads the manifest object, which the following fills
+// use to locate the objects of the maps.  It is called by the first
+// fill, and again whenever a map already filled from the current
+// manifest is filled, so that the maps filled by a single call to
+// FillCaches always come from the same snapshot.  When the source is
+// signed, the manifest must be signed as well.
+func (s *Source) LoadManifest() error {
+	if s.manifestKey == "" {
+		return errors.New("no manifest configured")
+	}
+
+	key := s.manifestPath()
+	out, err := getObject(s.client, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	var b []byte
+	if s.signed {
+		b, err = s.verify(ManifestEntry{Key: key}, out.Body)
+		if err != nil {
+			return errors.Wrap(err, "verifying signature")
+		}
+	} else if b, err = ioutil.ReadAll(out.Body); err != nil {
+		return err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return errors.Wrap(err, "json decoding manifest")
+	}
+
+	s.manifest = &m
+	s.filled = map[string]bool{}
+	return nil
+}
+
+// openMap opens the object holding the named map, as listed by the
+// manifest if one is configured, or else the first existing object
+// among the candidate keys.
+func (s *Source) openMap(name string) (ManifestEntry, *object, error) {
+	if s.manifestKey == "" {
+		return s.openFirst(s.candidates(name))
+	}
+
+	if s.manifest == nil || s.filled[name] {
+		if err := s.LoadManifest(); err != nil {
+			return ManifestEntry{}, nil, errors.Wrap(err, "reading manifest")
+		}
+	}
+	s.filled[name] = true
+
+	loc, ok := s.manifest.Maps[name]
+	if !ok {
+		return ManifestEntry{}, nil, errors.Errorf("map %s is not in the manifest", name)
+	}
+	obj, err := s.open(loc.Key, loc.VersionID)
+	return loc, obj, err
+}
+
+// manifestPath returns the key of the manifest object.
+func (s *Source) manifestPath() string {
+	return strings.TrimPrefix(strings.ReplaceAll(s.manifestKey, "{prefix}", s.prefix), "/")
+}
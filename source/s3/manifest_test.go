@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/MiLk/nsscache-go"
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource_Manifest(t *testing.T) {
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"nss/manifest.json": {Body: `{"maps": {
+			"passwd": {"key": "nss/passwd", "version_id": "1"},
+			"shadow": {"key": "nss/shadow.json.gz", "version_id": "1"},
+			"group": {"key": "nss/group"}
+		}}`},
+		"nss/passwd?versionId=1":         {Body: `[{"name": "foo", "passwd": "x", "uid": 1000, "gid": 1000, "dir": "/home/foo", "shell": "/bin/bash"}]`},
+		"nss/passwd?versionId=2":         {Body: `[{"name": "bar", "passwd": "x", "uid": 1001, "gid": 1001, "dir": "/home/bar", "shell": "/bin/bash"}]`},
+		"nss/passwd":                     {Body: `[{"name": "bar", "passwd": "x", "uid": 1001, "gid": 1001, "dir": "/home/bar", "shell": "/bin/bash"}]`},
+		"nss/shadow.json.gz?versionId=1": {Body: gzipString(t, `[{"name": "foo", "passwd": "*"}]`)},
+		"nss/shadow.json.gz?versionId=2": {Body: gzipString(t, `[{"name": "bar", "passwd": "*"}]`)},
+		"nss/group":                      {Body: `[{"name": "foo", "gid": 1000}]`},
+	})
+	src, err := NewSource(svc, "testing-bucket", Prefix("nss"), ManifestKey("{prefix}/manifest.json"))
+	assert.Nil(t, err)
+
+	fill := func() map[string]string {
+		cm := nsscache.NewCaches()
+		assert.Nil(t, cm.FillCaches(src))
+		r := map[string]string{}
+		for name, c := range cm {
+			var b bytes.Buffer
+			_, err := c.WriteTo(&b)
+			assert.Nil(t, err)
+			r[name] = b.String()
+		}
+		return r
+	}
+
+	expected := map[string]string{
+		"passwd": "foo:x:1000:1000::/home/foo:/bin/bash\n",
+		"shadow": "foo:*:::::::\n",
+		"group":  "foo:x:1000:\n",
+	}
+	assert.Equal(t, expected, fill())
+	assert.Len(t, svc.inputs, 4)
+
+	// A publish lands after the passwd map was read: the other maps are
+	// still read from the same snapshot.
+	c := cache.NewCache()
+	assert.Nil(t, src.FillPasswdCache(c))
+	svc.objects["nss/manifest.json"].Body = `{"maps": {
+		"passwd": {"key": "nss/passwd", "version_id": "2"},
+		"shadow": {"key": "nss/shadow.json.gz", "version_id": "2"},
+		"group": {"key": "nss/group"}
+	}}`
+	c = cache.NewCache()
+	assert.Nil(t, src.FillShadowCache(c))
+	var b bytes.Buffer
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:*:::::::\n", b.String())
+	assert.Nil(t, src.FillGroupCache(cache.NewCache()))
+
+	// The next fill reads the new snapshot
+	expected["passwd"] = "bar:x:1001:1001::/home/bar:/bin/bash\n"
+	expected["shadow"] = "bar:*:::::::\n"
+	assert.Equal(t, expected, fill())
+
+	// Maps missing from the manifest are an error
+	svc.objects["nss/manifest.json"].Body = `{"maps": {"passwd": {"key": "nss/passwd"}}}`
+	assert.Nil(t, src.LoadManifest())
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "map group is not in the manifest")
+	}
+
+	svc.objects["nss/manifest.json"].Body = `{"maps": `
+	err = src.LoadManifest()
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "json decoding manifest")
+	}
+}
+
+func TestSource_SignedManifest(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	manifest := `{"maps": {"group": {"key": "group", "version_id": "1", "signature_version_id": "3"}}}`
+	group := `[{"name": "foo", "gid": 1000}]`
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"manifest.json":           {Body: manifest},
+		"manifest.json.sig":       {Body: string(ed25519.Sign(priv, []byte(manifest)))},
+		"group?versionId=1":       {Body: group},
+		"group.sig?versionId=3":   {Body: string(ed25519.Sign(priv, []byte(group)))},
+		"group.sig":               {Body: "not the signature of the version"},
+		"other-manifest.json":     {Body: manifest},
+		"other-manifest.json.sig": {Body: "invalid"},
+	})
+
+	src, err := NewSource(svc, "testing-bucket", ManifestKey("manifest.json"), Signed(Ed25519Key(pub)))
+	assert.Nil(t, err)
+	c := cache.NewCache()
+	assert.Nil(t, src.FillGroupCache(c))
+	var b bytes.Buffer
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", b.String())
+
+	src, err = NewSource(svc, "testing-bucket", ManifestKey("other-manifest.json"), Signed(Ed25519Key(pub)))
+	assert.Nil(t, err)
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "reading manifest: verifying signature: invalid signature for object other-manifest.json")
+	}
+}
@@ -0,0 +1,127 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// Publish uploads all the entries of the cache as the object of the
+// named map, e.g. `passwd`, under the first of its candidate keys and
+// in the format read by the source, compressed and signed according to
+// the options.  It returns the location of the object, which a
+// manifest may list.
+func (s *Source) Publish(name string, c *cache.Cache) (ManifestEntry, error) {
+	keys := s.candidates(name)
+	if len(keys) == 0 {
+		return ManifestEntry{}, errors.New("no key configured")
+	}
+	loc := ManifestEntry{Key: keys[0]}
+
+	_, encoding := trimCompression(loc.Key)
+	if encoding == "" {
+		encoding = s.compression
+	}
+	var buf bytes.Buffer
+	w, err := compress(&buf, encoding)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	if err := encode(objectFormat(loc.Key, s.format), w, c); err != nil {
+		return ManifestEntry{}, errors.Wrap(err, "encoding")
+	}
+	if err := w.Close(); err != nil {
+		return ManifestEntry{}, errors.Wrap(err, "compressing")
+	}
+
+	loc.VersionID, loc.SignatureVersionID, err = s.upload(loc.Key, buf.Bytes(), encoding)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	return loc, nil
+}
+
+// PublishCaches uploads every cache of the map, keyed by map name such
+// as an nsscache.CacheMap, with Publish.  When a manifest key is
+// configured, the manifest listing the objects just uploaded is
+// uploaded last, so that the source never reads a partial snapshot.
+func (s *Source) PublishCaches(cm map[string]*cache.Cache) error {
+	names := make([]string, 0, len(cm))
+	for name := range cm {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m := Manifest{Maps: map[string]ManifestEntry{}}
+	for _, name := range names {
+		loc, err := s.Publish(name, cm[name])
+		if err != nil {
+			return errors.Wrapf(err, "publishing %s", name)
+		}
+		m.Maps[name] = loc
+	}
+
+	if s.manifestKey == "" {
+		return nil
+	}
+	return errors.Wrap(s.PublishManifest(m), "publishing manifest")
+}
+
+// PublishManifest uploads the manifest under the configured manifest
+// key, signed according to the options.
+func (s *Source) PublishManifest(m Manifest) error {
+	if s.manifestKey == "" {
+		return errors.New("no manifest configured")
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return errors.Wrap(err, "json encoding")
+	}
+	_, _, err = s.upload(s.manifestPath(), b, "")
+	return err
+}
+
+// upload puts the content of an object and, if a signer is configured,
+// its detached signature.  It returns the versions of both, which are
+// empty unless the bucket is versioned.
+func (s *Source) upload(key string, b []byte, encoding string) (string, string, error) {
+	var signature []byte
+	if s.signer != nil {
+		var err error
+		if signature, err = s.signer.Sign(b); err != nil {
+			return "", "", errors.Wrap(err, "signing")
+		}
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(b),
+	}
+	if encoding != "" {
+		input.ContentEncoding = aws.String(encoding)
+	}
+	out, err := s.client.PutObject(input)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error putting object %s in bucket %s", key, s.bucket)
+	}
+	versionID := aws.StringValue(out.VersionId)
+
+	if signature == nil {
+		return versionID, "", nil
+	}
+	out, err = s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key + signatureSuffix),
+		Body:   bytes.NewReader(signature),
+	})
+	if err != nil {
+		return "", "", errors.Wrapf(err, "error putting object %s in bucket %s", key+signatureSuffix, s.bucket)
+	}
+	return versionID, aws.StringValue(out.VersionId), nil
+}
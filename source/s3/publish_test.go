@@ -0,0 +1,128 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"aead.dev/minisign"
+	"github.com/MiLk/nsscache-go"
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func testCaches(t *testing.T) nsscache.CacheMap {
+	cm := nsscache.NewCaches()
+
+	p := &cache.PasswdEntry{}
+	assert.Nil(t, p.Parse("foo:x:1000:1000:Foo Bar:/home/foo:/bin/bash"))
+	cm["passwd"].Add(p)
+	s := &cache.ShadowEntry{}
+	assert.Nil(t, s.Parse("foo:$6$hash:18000:0:99999:7:::"))
+	cm["shadow"].Add(s)
+	g := &cache.GroupEntry{}
+	assert.Nil(t, g.Parse("users:x:100:foo,bar"))
+	cm["group"].Add(g)
+
+	return cm
+}
+
+func writeCaches(t *testing.T, cm nsscache.CacheMap) map[string]string {
+	r := map[string]string{}
+	for name, c := range cm {
+		var b bytes.Buffer
+		_, err := c.WriteTo(&b)
+		assert.Nil(t, err)
+		r[name] = b.String()
+	}
+	return r
+}
+
+func TestSource_PublishRoundTrip(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	msPub, msPriv, err := minisign.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		keys []string
+	}{
+		{
+			name: "default",
+			opts: []Option{Prefix("nss")},
+			keys: []string{"nss/group", "nss/passwd", "nss/shadow"},
+		},
+		{
+			name: "json lines gzip",
+			opts: []Option{Prefix("nss/v2"), KeyTemplate("{prefix}/{map}.jsonl.gz")},
+			keys: []string{"nss/v2/group.jsonl.gz", "nss/v2/passwd.jsonl.gz", "nss/v2/shadow.jsonl.gz"},
+		},
+		{
+			name: "colon separated zstd",
+			opts: []Option{ObjectFormat(FormatColonSeparated), Compression("zstd"), MapKey("group", "shared/group")},
+			keys: []string{"shared/group", "passwd", "shadow"},
+		},
+		{
+			name: "signed ed25519",
+			opts: []Option{Compression("gzip"), SignWith(Ed25519Signer(edPriv)), Signed(Ed25519Key(edPub))},
+			keys: []string{"group", "group.sig", "passwd", "passwd.sig", "shadow", "shadow.sig"},
+		},
+		{
+			name: "manifest minisign",
+			opts: []Option{KeyTemplate("{map}.json"), ManifestKey("manifest.json"), SignWith(MinisignSigner(msPriv)), Signed(MinisignKey(msPub))},
+			keys: []string{"group.json", "group.json.sig", "passwd.json", "passwd.json.sig", "shadow.json", "shadow.json.sig", "manifest.json", "manifest.json.sig"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{})
+			src, err := NewSource(svc, "testing-bucket", tc.opts...)
+			assert.Nil(t, err)
+
+			cm := testCaches(t)
+			assert.Nil(t, src.PublishCaches(cm))
+
+			assert.Equal(t, tc.keys, svc.puts)
+
+			read := nsscache.NewCaches()
+			assert.Nil(t, read.FillCaches(src))
+			assert.Equal(t, writeCaches(t, cm), writeCaches(t, read))
+		})
+	}
+}
+
+func TestSource_PublishManifest(t *testing.T) {
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{})
+	src, err := NewSource(svc, "testing-bucket", Prefix("nss"), ManifestKey("{prefix}/manifest.json"))
+	assert.Nil(t, err)
+
+	cm := testCaches(t)
+	assert.Nil(t, src.PublishCaches(cm))
+	assert.Nil(t, src.LoadManifest())
+	assert.Equal(t, &Manifest{Maps: map[string]ManifestEntry{
+		"group":  {Key: "nss/group", VersionID: "1"},
+		"passwd": {Key: "nss/passwd", VersionID: "2"},
+		"shadow": {Key: "nss/shadow", VersionID: "3"},
+	}}, src.manifest)
+
+	// Objects overwritten without publishing a new manifest are not
+	// read.
+	_, err = src.Publish("passwd", cache.NewCache())
+	assert.Nil(t, err)
+	read := nsscache.NewCaches()
+	assert.Nil(t, read.FillCaches(src))
+	assert.Equal(t, writeCaches(t, cm), writeCaches(t, read))
+
+	src, err = NewSource(svc, "testing-bucket")
+	assert.Nil(t, err)
+	assert.NotNil(t, src.PublishManifest(Manifest{}))
+
+	src, err = NewSource(svc, "testing-bucket", Compression("lz4"))
+	assert.Nil(t, err)
+	_, err = src.Publish("passwd", cm["passwd"])
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "unsupported encoding lz4")
+	}
+}
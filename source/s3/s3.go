@@ -1,8 +1,8 @@
 package s3
 
 import (
-	"encoding/json"
-	"fmt"
+	"bytes"
+	"io"
 
 	"github.com/MiLk/nsscache-go/cache"
 	"github.com/MiLk/nsscache-go/source"
@@ -15,51 +15,180 @@ Source describes a source.Source for S3 backends:
   - prefix: the path within the S3 bucket to the passwd, shadow and group files
   - bucket: the name of the S3 bucket
   - client: the S3 client
+  - stateDir: the directory where the state of conditional requests is kept
+  - format: the format of the objects without a known extension
+  - templates: the templates of the keys of the objects, tried in order
+  - mapKeys: the keys of the objects of specific maps, tried in order
+  - signed: whether every object must be signed
+  - verifiers: the public keys one of which must have signed every object
+  - manifestKey: the key of the manifest listing the objects of the maps
+  - compression: the compression of the objects published
+  - signer: the private key signing the objects published
 */
 type Source struct {
-	prefix string
-	bucket string
-	client s3iface.S3API
+	prefix    string
+	bucket    string
+	client    s3iface.S3API
+	stateDir  string
+	format    Format
+	templates []string
+	mapKeys   map[string][]string
+	signed    bool
+	verifiers []Verifier
+
+	manifestKey string
+	manifest    *Manifest
+	filled      map[string]bool
+
+	compression string
+	signer      Signer
+
+	unchanged map[string]bool
+}
+
+// Option represents a function which will make some change to the
+// source during initialization.
+type Option func(*Source)
+
+// Prefix is an option function which will set the path within the
+// bucket to the passwd, shadow and group files.
+func Prefix(p string) Option {
+	return func(s *Source) { s.prefix = p }
+}
+
+// StateDir is an option function which will make the source remember
+// the ETag and Last-Modified of every object downloaded, and keep a
+// copy of them, in the provided directory.  Subsequent downloads are
+// conditional, and the local copy is used when the object has not
+// been modified.  See Unchanged.
+func StateDir(dir string) Option {
+	return func(s *Source) { s.stateDir = dir }
+}
+
+// ObjectFormat is an option function which will set the format of the
+// objects whose key has no known extension.  Keys ending with `.json`
+// are always read as FormatJSON, and keys ending with `.jsonl` or
+// `.ndjson` as FormatJSONLines.  Defaults to FormatJSON.
+func ObjectFormat(f Format) Option {
+	return func(s *Source) { s.format = f }
+}
+
+// KeyTemplate is an option function which will set the templates of
+// the keys of the objects.  The `{prefix}` and `{map}` placeholders are
+// replaced by the prefix and the name of the map, and a leading slash
+// left by an empty prefix is removed.  When several templates are
+// provided, they are tried in order until an object exists.  Defaults
+// to `{prefix}/{map}`.
+func KeyTemplate(templates ...string) Option {
+	return func(s *Source) { s.templates = templates }
+}
+
+// MapKey is an option function which will set the keys of the object
+// of the named map, overriding the key templates.  The keys may
+// contain the same placeholders as the key templates, and when several
+// keys are provided, they are tried in order until an object exists.
+func MapKey(name string, keys ...string) Option {
+	return func(s *Source) { s.mapKeys[name] = keys }
+}
+
+// Signed is an option function which will require every object to
+// come with a detached signature, stored next to it under the same key
+// with the `.sig` suffix, made by one of the provided keys.  The
+// content of an object is only decoded once its signature has been
+// verified, and filling the cache fails if the signature is missing or
+// invalid.  NewSource fails if no key is provided.
+func Signed(keys ...Verifier) Option {
+	return func(s *Source) {
+		s.signed = true
+		s.verifiers = append(s.verifiers, keys...)
+	}
+}
+
+// ManifestKey is an option function which will make the source read
+// the manifest object with the provided key, which may contain the
+// `{prefix}` placeholder, and fetch exactly the objects it lists.  The
+// key templates and map keys are then ignored.  See Manifest.
+func ManifestKey(key string) Option {
+	return func(s *Source) { s.manifestKey = key }
+}
+
+// Compression is an option function which will make Publish compress
+// the objects with the given encoding, `gzip` or `zstd`, unless their
+// key has a compression extension.
+func Compression(encoding string) Option {
+	return func(s *Source) { s.compression = encoding }
+}
+
+// SignWith is an option function which will make Publish upload a
+// detached signature, made with the provided signer, next to every
+// object, as required by the Signed option.
+func SignWith(signer Signer) Option {
+	return func(s *Source) { s.signer = signer }
 }
 
 // CreateSource returns a new Source for fetching data from S3
 // backends.
 func CreateSource(client s3iface.S3API, prefix string, bucket string) source.Source {
-	return &Source{
-		client: client,
-		prefix: prefix,
-		bucket: bucket,
-	}
+	s, _ := NewSource(client, bucket, Prefix(prefix))
+	return s
 }
 
-func (s *Source) run(key string, c *cache.Cache, createEntry func() cache.Entry) error {
-	if s.prefix != "" {
-		key = fmt.Sprintf("%s/%s", s.prefix, key)
+// NewSource creates a new S3 source reading from the provided bucket
+// using the options provided.
+func NewSource(client s3iface.S3API, bucket string, opts ...Option) (*Source, error) {
+	s := Source{
+		client:    client,
+		bucket:    bucket,
+		templates: []string{defaultKeyTemplate},
+		mapKeys:   map[string][]string{},
+		unchanged: map[string]bool{},
+		filled:    map[string]bool{},
 	}
 
-	data, err := DownloadS3Data(s.client, s.bucket, key)
-
-	if err != nil {
-		return errors.Wrap(err, "downloading from S3")
+	for _, opt := range opts {
+		opt(&s)
 	}
 
-	r := make([]interface{}, 0)
-	if err := json.Unmarshal([]byte(data), &r); err != nil {
-		return errors.Wrap(err, "json decoding")
+	if s.signed && len(s.verifiers) == 0 {
+		return nil, errors.New("signed source without any key")
 	}
 
-	for _, elem := range r {
-		str, _ := json.Marshal(elem)
+	return &s, nil
+}
+
+// Unchanged returns true if the object for the named cache was not
+// modified since the previous download during the last fill, in which
+// case the cache was filled from the local copy.  Callers may use it
+// to skip writing the cache files entirely.
+func (s *Source) Unchanged(name string) bool {
+	return s.unchanged[name]
+}
+
+func (s *Source) run(name string, c *cache.Cache, createEntry func() cache.Entry) error {
+	loc, obj, err := s.openMap(name)
 
-		e := createEntry()
-		if err := json.Unmarshal([]byte(str), e); err != nil {
-			return errors.Wrap(err, "json does not match entry format")
+	if err != nil {
+		return errors.Wrap(err, "downloading from S3")
+	}
+	defer obj.Close()
+	s.unchanged[name] = obj.unchanged
+
+	var r io.Reader = obj
+	if s.signed {
+		b, err := s.verify(loc, obj)
+		if err != nil {
+			return errors.Wrap(err, "verifying signature")
 		}
+		r = bytes.NewReader(b)
+	}
 
-		c.Add(e)
+	dr, err := decompress(r, objectEncoding(loc.Key, obj.contentEncoding))
+	if err != nil {
+		return errors.Wrap(err, "decompressing")
 	}
+	defer dr.Close()
 
-	return nil
+	return decode(objectFormat(loc.Key, s.format), dr, c, createEntry)
 }
 
 // FillPasswdCache downloads shadow file from S3, parses the JSON and
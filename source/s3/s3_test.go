@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/MiLk/nsscache-go/cache"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -74,8 +77,10 @@ func TestSource_FillPasswdCache_JSONDecodingError(t *testing.T) {
 	c := cache.NewCache()
 
 	err = src.FillPasswdCache(c)
-	expectedErr := "json decoding: invalid character '\\n' in string literal"
-	assert.Equal(t, expectedErr, err.Error())
+	// The end of the encoding/json message depends on the version of Go
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "json decoding at index 0: invalid character '\\n' in string")
+	}
 }
 
 func TestSource_FillPasswdCache_BadEntryFormat(t *testing.T) {
@@ -99,7 +104,7 @@ func TestSource_FillPasswdCache_BadEntryFormat(t *testing.T) {
 	c := cache.NewCache()
 
 	err = src.FillPasswdCache(c)
-	expectedErr := "json does not match entry format: json: cannot unmarshal string into Go struct field PasswdEntry.uid of type uint32"
+	expectedErr := "json does not match entry format at index 0: json: cannot unmarshal string into Go struct field PasswdEntry.uid of type uint32"
 	assert.Equal(t, expectedErr, err.Error())
 }
 
@@ -182,3 +187,111 @@ func TestSource_FillGroupCache_OK(t *testing.T) {
 	assert.EqualValues(t, 29, n)
 	assert.Equal(t, "group:123!!:1000:foo,var,baz\n", b.String())
 }
+
+func TestSource_StateDir(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"nsscache/group": {
+			Body:         `[{"name": "foo", "gid": 1000}]`,
+			ETag:         `"v1"`,
+			LastModified: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	})
+	src, err := NewSource(svc, "testing-bucket", Prefix("nsscache"), StateDir(dir))
+	assert.Nil(t, err)
+
+	fill := func() string {
+		c := cache.NewCache()
+		assert.Nil(t, src.FillGroupCache(c))
+		var b bytes.Buffer
+		_, err := c.WriteTo(&b)
+		assert.Nil(t, err)
+		return b.String()
+	}
+
+	// First download is unconditional
+	assert.Equal(t, "foo:x:1000:\n", fill())
+	assert.False(t, src.Unchanged("group"))
+	assert.Nil(t, svc.inputs[0].IfNoneMatch)
+
+	// Not modified, the local copy is used
+	assert.Equal(t, "foo:x:1000:\n", fill())
+	assert.True(t, src.Unchanged("group"))
+	assert.Equal(t, `"v1"`, aws.StringValue(svc.inputs[1].IfNoneMatch))
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), aws.TimeValue(svc.inputs[1].IfModifiedSince))
+
+	// The state is kept across sources
+	src, err = NewSource(svc, "testing-bucket", Prefix("nsscache"), StateDir(dir))
+	assert.Nil(t, err)
+	svc.objects["nsscache/group"].Body = `[{"name": "bar", "gid": 1001}]`
+	svc.objects["nsscache/group"].ETag = `"v2"`
+	assert.Equal(t, "bar:x:1001:\n", fill())
+	assert.False(t, src.Unchanged("group"))
+	assert.Equal(t, `"v1"`, aws.StringValue(svc.inputs[2].IfNoneMatch))
+
+	assert.Equal(t, "bar:x:1001:\n", fill())
+	assert.True(t, src.Unchanged("group"))
+
+	// The local copy disappeared
+	assert.Nil(t, os.Remove(src.copyPath("nsscache/group")))
+	assert.Equal(t, "bar:x:1001:\n", fill())
+	assert.False(t, src.Unchanged("group"))
+	assert.Nil(t, svc.inputs[4].IfNoneMatch)
+
+	// Corrupted state
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "state.json"), []byte("{"), 0644))
+	c := cache.NewCache()
+	assert.NotNil(t, src.FillGroupCache(c))
+}
+
+func TestSource_StateDir_CopyPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp", "nsscache-go-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	objects := map[string]*MockS3Object{
+		"a/b_c":      {Body: "a/b_c", ETag: `"1"`},
+		"a_b/c":      {Body: "a_b/c", ETag: `"2"`},
+		"state.json": {Body: "state.json", ETag: `"3"`},
+	}
+	svc := CreateMockS3ObjectsClient(objects)
+	src, err := NewSource(svc, "testing-bucket", StateDir(dir))
+	assert.Nil(t, err)
+
+	// The second time, every object is read from its local copy.
+	for i := 0; i < 2; i++ {
+		for key := range objects {
+			o, err := src.open(key, "")
+			assert.Nil(t, err)
+			b, err := ioutil.ReadAll(o)
+			assert.Nil(t, err)
+			assert.Nil(t, o.Close())
+			assert.Equal(t, key, string(b))
+			assert.Equal(t, i == 1, o.unchanged)
+		}
+	}
+}
+
+func TestSource_FillGroupCache_DecodingErrors(t *testing.T) {
+	for _, tc := range []struct {
+		body        string
+		expectedErr string
+	}{
+		{`{"name": "group"}`, "json decoding: expected an array of entries"},
+		{`[{"name": "foo", "gid": 1000}, {"name": "bar", "gid": "1001"}]`, "json does not match entry format at index 1: json: cannot unmarshal string into Go struct field GroupEntry.gid of type uint32"},
+		{`[{"name": "foo", "gid": 1000}, {"name": "bar", "gid": 1001}`, "unexpected"},
+		{``, "json decoding: EOF"},
+	} {
+		svc := CreateMockS3GetObjectClient(tc.body, nil)
+		src := CreateSource(svc, "nsscache", "testing-bucket")
+		c := cache.NewCache()
+
+		err := src.FillGroupCache(c)
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+
+	"aead.dev/minisign"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// signatureSuffix is appended to the key of an object to get the key
+// of its detached signature.
+const signatureSuffix = ".sig"
+
+// Verifier verifies the detached signature of an object against a
+// public key.
+type Verifier interface {
+	Verify(message, signature []byte) bool
+}
+
+type ed25519Verifier ed25519.PublicKey
+
+// Ed25519Key returns a Verifier for the signatures made with the
+// private key of the given ed25519 public key.  The signature object
+// contains the 64 bytes of the signature, either raw or base64
+// encoded.
+func Ed25519Key(key ed25519.PublicKey) Verifier {
+	return ed25519Verifier(key)
+}
+
+func (v ed25519Verifier) Verify(message, signature []byte) bool {
+	if len(signature) != ed25519.SignatureSize {
+		b, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+		if err != nil {
+			return false
+		}
+		signature = b
+	}
+	if len(v) != ed25519.PublicKeySize || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(v), message, signature)
+}
+
+type minisignVerifier minisign.PublicKey
+
+// MinisignKey returns a Verifier for the signatures made by minisign
+// with the private key of the given public key.
+func MinisignKey(key minisign.PublicKey) Verifier {
+	return minisignVerifier(key)
+}
+
+func (v minisignVerifier) Verify(message, signature []byte) bool {
+	return minisign.Verify(minisign.PublicKey(v), message, signature)
+}
+
+// verify reads the content of the object at the given location and
+// checks it against its detached signature.  The content is only
+// returned if the signature was made by one of the keys of the source.
+func (s *Source) verify(loc ManifestEntry, r io.Reader) ([]byte, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(loc.Key + signatureSuffix),
+	}
+	if loc.SignatureVersionID != "" {
+		input.VersionId = aws.String(loc.SignatureVersionID)
+	}
+	out, err := getObject(s.client, input)
+	if err != nil {
+		return nil, errors.Wrap(err, "downloading signature")
+	}
+	defer out.Body.Close()
+	signature, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "downloading signature")
+	}
+
+	message, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range s.verifiers {
+		if k.Verify(message, signature) {
+			return message, nil
+		}
+	}
+	return nil, errors.Errorf("invalid signature for object %s", loc.Key)
+}
+
+// Signer makes the detached signatures of the objects published.
+type Signer interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+type ed25519Signer ed25519.PrivateKey
+
+// Ed25519Signer returns a Signer making raw ed25519 signatures with
+// the given private key.
+func Ed25519Signer(key ed25519.PrivateKey) Signer {
+	return ed25519Signer(key)
+}
+
+func (s ed25519Signer) Sign(message []byte) ([]byte, error) {
+	if len(s) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	return ed25519.Sign(ed25519.PrivateKey(s), message), nil
+}
+
+type minisignSigner minisign.PrivateKey
+
+// MinisignSigner returns a Signer making minisign signatures with the
+// given private key.
+func MinisignSigner(key minisign.PrivateKey) Signer {
+	return minisignSigner(key)
+}
+
+func (s minisignSigner) Sign(message []byte) ([]byte, error) {
+	return minisign.Sign(minisign.PrivateKey(s), message), nil
+}
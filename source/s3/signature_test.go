@@ -0,0 +1,144 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+
+	"aead.dev/minisign"
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource_Signed(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	otherPub, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	msPub, msPriv, err := minisign.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	body := `[{"name": "foo", "gid": 1000}]`
+	compressed := gzipString(t, body)
+
+	for _, tc := range []struct {
+		name        string
+		keys        []Verifier
+		objects     map[string]*MockS3Object
+		expectedErr string
+	}{
+		{
+			name: "ed25519",
+			keys: []Verifier{Ed25519Key(edPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: body},
+				"group.sig": {Body: string(ed25519.Sign(edPriv, []byte(body)))},
+			},
+		},
+		{
+			name: "ed25519 base64",
+			keys: []Verifier{Ed25519Key(edPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: body},
+				"group.sig": {Body: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, []byte(body))) + "\n"},
+			},
+		},
+		{
+			name: "second key",
+			keys: []Verifier{Ed25519Key(otherPub), Ed25519Key(edPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: body},
+				"group.sig": {Body: string(ed25519.Sign(edPriv, []byte(body)))},
+			},
+		},
+		{
+			name: "minisign",
+			keys: []Verifier{MinisignKey(msPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: body},
+				"group.sig": {Body: string(minisign.Sign(msPriv, []byte(body)))},
+			},
+		},
+		{
+			name: "compressed",
+			keys: []Verifier{MinisignKey(msPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: compressed, ContentEncoding: "gzip"},
+				"group.sig": {Body: string(minisign.Sign(msPriv, []byte(compressed)))},
+			},
+		},
+		{
+			name: "missing signature",
+			keys: []Verifier{Ed25519Key(edPub)},
+			objects: map[string]*MockS3Object{
+				"group": {Body: body},
+			},
+			expectedErr: "verifying signature: downloading signature",
+		},
+		{
+			name: "wrong key",
+			keys: []Verifier{Ed25519Key(edPub), MinisignKey(msPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: body},
+				"group.sig": {Body: string(ed25519.Sign(otherPriv, []byte(body)))},
+			},
+			expectedErr: "verifying signature: invalid signature for object group",
+		},
+		{
+			name: "tampered",
+			keys: []Verifier{Ed25519Key(edPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: `[{"name": "root", "gid": 0}]`},
+				"group.sig": {Body: string(ed25519.Sign(edPriv, []byte(body)))},
+			},
+			expectedErr: "verifying signature: invalid signature for object group",
+		},
+		{
+			name: "garbage signature",
+			keys: []Verifier{Ed25519Key(edPub), MinisignKey(msPub)},
+			objects: map[string]*MockS3Object{
+				"group":     {Body: body},
+				"group.sig": {Body: "not a signature"},
+			},
+			expectedErr: "verifying signature: invalid signature for object group",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			svc := CreateMockS3ObjectsClient(tc.objects)
+			src, err := NewSource(svc, "testing-bucket", Signed(tc.keys...))
+			assert.Nil(t, err)
+
+			c := cache.NewCache()
+			err = src.FillGroupCache(c)
+			if tc.expectedErr != "" {
+				if assert.NotNil(t, err) {
+					assert.Contains(t, err.Error(), tc.expectedErr)
+				}
+				b, err := c.MarshalJSON()
+				assert.Nil(t, err)
+				assert.Equal(t, "[]", string(b))
+				return
+			}
+			assert.Nil(t, err)
+			var b bytes.Buffer
+			_, err = c.WriteTo(&b)
+			assert.Nil(t, err)
+			assert.Equal(t, "foo:x:1000:\n", b.String())
+		})
+	}
+}
+
+func TestSource_SignedWithoutKeys(t *testing.T) {
+	svc := CreateMockS3ObjectsClient(map[string]*MockS3Object{
+		"group": {Body: `[{"name": "foo", "gid": 1000}]`},
+	})
+
+	_, err := NewSource(svc, "testing-bucket", Signed())
+	assert.EqualError(t, err, "signed source without any key")
+
+	var keys []Verifier
+	_, err = NewSource(svc, "testing-bucket", Signed(keys...))
+	assert.EqualError(t, err, "signed source without any key")
+}
@@ -0,0 +1,152 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	"github.com/MiLk/nsscache-go/source"
+)
+
+// stateFile is the name of the file, within the state directory,
+// holding the ETag and Last-Modified of the objects downloaded.
+const stateFile = "state.json"
+
+// objectState describes the last downloaded version of an object.
+type objectState struct {
+	ETag            string    `json:"etag,omitempty"`
+	LastModified    time.Time `json:"last_modified,omitempty"`
+	ContentEncoding string    `json:"content_encoding,omitempty"`
+}
+
+// object is the content of a downloaded object.
+type object struct {
+	io.ReadCloser
+	contentEncoding string
+	unchanged       bool
+}
+
+// loadState reads the state file from the state directory.  A missing
+// state file results in an empty state.
+func (s *Source) loadState() (map[string]objectState, error) {
+	state := map[string]objectState{}
+	b, err := ioutil.ReadFile(filepath.Join(s.stateDir, stateFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, errors.Wrap(err, "json decoding state")
+	}
+	return state, nil
+}
+
+// saveState atomically replaces the state file in the state directory.
+func (s *Source) saveState(state map[string]objectState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return source.ReplaceFile(filepath.Join(s.stateDir, stateFile), bytes.NewReader(b))
+}
+
+// copyPath returns the path of the local copy of the object with the
+// given key, named after its hash so that distinct keys never share a
+// copy.
+func (s *Source) copyPath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.stateDir, hex.EncodeToString(sum[:]))
+}
+
+// open returns the content of the object with the given key and, if
+// not empty, version.  When a state directory is configured, the
+// download is conditional and the local copy is read if the object was
+// not modified.
+func (s *Source) open(key, versionID string) (*object, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	if s.stateDir == "" {
+		out, err := getObject(s.client, input)
+		if err != nil {
+			return nil, err
+		}
+		return &object{
+			ReadCloser:      out.Body,
+			contentEncoding: aws.StringValue(out.ContentEncoding),
+		}, nil
+	}
+
+	state, err := s.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	previous, ok := state[key]
+	if _, err := os.Stat(s.copyPath(key)); ok && err == nil {
+		if previous.ETag != "" {
+			input.IfNoneMatch = aws.String(previous.ETag)
+		}
+		if !previous.LastModified.IsZero() {
+			input.IfModifiedSince = aws.Time(previous.LastModified)
+		}
+	}
+
+	out, err := getObject(s.client, input)
+	unchanged := isNotModified(err)
+	if err != nil && !unchanged {
+		return nil, err
+	}
+
+	if !unchanged {
+		err = source.ReplaceFile(s.copyPath(key), out.Body)
+		out.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		state[key] = objectState{
+			ETag:            aws.StringValue(out.ETag),
+			LastModified:    aws.TimeValue(out.LastModified),
+			ContentEncoding: aws.StringValue(out.ContentEncoding),
+		}
+		if err := s.saveState(state); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Open(s.copyPath(key))
+	if err != nil {
+		return nil, err
+	}
+	return &object{
+		ReadCloser:      f,
+		contentEncoding: state[key].ContentEncoding,
+		unchanged:       unchanged,
+	}, nil
+}
+
+// isNotModified returns true if the error is the response of S3 to a
+// conditional request for an object which was not modified.
+func isNotModified(err error) bool {
+	var reqErr awserr.RequestFailure
+	return errors.As(err, &reqErr) && reqErr.StatusCode() == http.StatusNotModified
+}
@@ -0,0 +1,106 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/MiLk/nsscache-go/cache"
+)
+
+// snapshotPath returns the path of the snapshot of the named map in
+// the directory.
+func snapshotPath(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+// saveSnapshot writes the entries of the cache of the named map as a
+// JSON array, atomically and only readable by the owner.
+func saveSnapshot(dir, name string, c *cache.Cache) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encoding snapshot of %s: %w", name, err)
+	}
+
+	if err := ReplaceFile(snapshotPath(dir, name), bytes.NewReader(b)); err != nil {
+		return fmt.Errorf("writing snapshot of %s: %w", name, err)
+	}
+	return nil
+}
+
+// loadSnapshot reads the entries of a snapshot.
+func loadSnapshot(p string, createEntry func() cache.Entry) ([]cache.Entry, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("decoding snapshot %s: %w", p, err)
+	}
+	entries := make([]cache.Entry, 0, len(items))
+	for i, item := range items {
+		e := createEntry()
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(e); err != nil {
+			return nil, fmt.Errorf("decoding snapshot %s at index %d: %w", p, i, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Snapshots is a Source filling the caches from the snapshots kept by
+// a Fallback or a Cached source in a directory, which hold the entries
+// of their source as read before any ACL.
+type Snapshots struct {
+	dir string
+}
+
+// NewSnapshots creates a new Source filling the caches from the
+// snapshots kept in the provided directory.
+func NewSnapshots(dir string) (*Snapshots, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("opening snapshot directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("opening snapshot directory: %s is not a directory", dir)
+	}
+	return &Snapshots{dir: dir}, nil
+}
+
+func (s *Snapshots) fill(name string, c *cache.Cache, createEntry func() cache.Entry) error {
+	entries, err := loadSnapshot(snapshotPath(s.dir, name), createEntry)
+	if err != nil {
+		return err
+	}
+	c.Add(entries...)
+	return nil
+}
+
+// FillPasswdCache fills the passwd cache from its snapshot.
+func (s *Snapshots) FillPasswdCache(c *cache.Cache) error {
+	return s.fill("passwd", c, func() cache.Entry {
+		return &cache.PasswdEntry{}
+	})
+}
+
+// FillShadowCache fills the shadow cache from its snapshot.
+func (s *Snapshots) FillShadowCache(c *cache.Cache) error {
+	return s.fill("shadow", c, func() cache.Entry {
+		return &cache.ShadowEntry{}
+	})
+}
+
+// FillGroupCache fills the group cache from its snapshot.
+func (s *Snapshots) FillGroupCache(c *cache.Cache) error {
+	return s.fill("group", c, func() cache.Entry {
+		return &cache.GroupEntry{}
+	})
+}
@@ -0,0 +1,310 @@
+// Package sql implements a source.Source reading the maps from a
+// database/sql database, with a configured query per map.
+package sql
+
+import (
+	gosql "database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/pkg/errors"
+)
+
+// DefaultMemberSeparator separates the members of a group aggregated
+// in a single column.
+const DefaultMemberSeparator = ","
+
+/*
+Source describes a source.Source for a SQL database:
+  - db: the database
+  - queries: the query of every map
+  - columns: the mapping from entry fields to columns, by map name
+  - memberSeparator: the separator of the aggregated group members
+*/
+type Source struct {
+	db              *gosql.DB
+	queries         map[string]query
+	columns         map[string]map[string]string
+	memberSeparator string
+}
+
+type query struct {
+	query string
+	args  []interface{}
+}
+
+// Option represents a function which will make some change to the
+// source during initialization.
+type Option func(*Source)
+
+// Query is an option function which will set the query, and its
+// arguments, returning the entries of the named map.  The columns are
+// matched by name with the JSON fields of the entries, e.g. `name`,
+// `uid`, `gid`, `gecos`, `dir` and `shell` for passwd.  See Column.
+//
+// The members of a group are read from the `mem` column, either
+// aggregated in a single row and split on the member separator, or with
+// one row per member.  The rows of the same group are merged, and a
+// NULL member is ignored so that groups without members can be
+// returned by an outer join.
+func Query(name, q string, args ...interface{}) Option {
+	return func(s *Source) { s.queries[name] = query{q, args} }
+}
+
+// Column is an option function which will set the column read for a
+// field of the entries of the named map, e.g. `login` for `name`.
+func Column(name, field, column string) Option {
+	return func(s *Source) {
+		if s.columns[name] == nil {
+			s.columns[name] = map[string]string{}
+		}
+		s.columns[name][field] = column
+	}
+}
+
+// MemberSeparator is an option function which will set the separator
+// of the group members aggregated in a single column.  The members are
+// not split if empty.  Defaults to DefaultMemberSeparator.
+func MemberSeparator(sep string) Option {
+	return func(s *Source) { s.memberSeparator = sep }
+}
+
+// NewSource creates a new SQL source for the database using the
+// options provided.
+func NewSource(db *gosql.DB, opts ...Option) (*Source, error) {
+	s := Source{
+		db:              db,
+		queries:         map[string]query{},
+		columns:         map[string]map[string]string{},
+		memberSeparator: DefaultMemberSeparator,
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s, nil
+}
+
+// row holds the values of the fields of a row.
+type row struct {
+	name   string
+	n      int
+	values map[string]gosql.NullString
+}
+
+func (r row) get(field string) string {
+	return r.values[field].String
+}
+
+func (r row) uint32(field string) (uint32, error) {
+	v, err := strconv.ParseUint(r.get(field), 10, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s in row %d of %s", field, r.n, r.name)
+	}
+	return uint32(v), nil
+}
+
+func (r row) int32(field string) (*int32, error) {
+	v, ok := r.values[field]
+	if !ok || !v.Valid || v.String == "" {
+		return nil, nil
+	}
+	i, err := strconv.ParseInt(v.String, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s in row %d of %s", field, r.n, r.name)
+	}
+	n := int32(i)
+	return &n, nil
+}
+
+// run executes the query of the named map and calls fill with every
+// row.  The query must return a column for each required field.
+func (s *Source) run(name string, required []string, fill func(row) error) error {
+	q, ok := s.queries[name]
+	if !ok {
+		return errors.Errorf("no query configured for %s", name)
+	}
+
+	rows, err := s.db.Query(q.query, q.args...)
+	if err != nil {
+		return errors.Wrapf(err, "querying %s", name)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return errors.Wrapf(err, "querying %s", name)
+	}
+	fields := make([]string, len(cols))
+	for i, col := range cols {
+		fields[i] = s.field(name, col)
+	}
+	for _, field := range required {
+		found := false
+		for _, f := range fields {
+			found = found || f == field
+		}
+		if !found {
+			return errors.Errorf("no column for %s in the query of %s", field, name)
+		}
+	}
+
+	values := make([]gosql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for n := 1; rows.Next(); n++ {
+		if err := rows.Scan(dest...); err != nil {
+			return errors.Wrapf(err, "reading row %d of %s", n, name)
+		}
+		r := row{name: name, n: n, values: map[string]gosql.NullString{}}
+		for i, f := range fields {
+			if f != "" {
+				r.values[f] = values[i]
+			}
+		}
+		for _, field := range required {
+			if !r.values[field].Valid {
+				return errors.Errorf("null %s in row %d of %s", field, n, name)
+			}
+		}
+		if err := fill(r); err != nil {
+			return err
+		}
+	}
+	return errors.Wrapf(rows.Err(), "querying %s", name)
+}
+
+// field returns the field read from the column of the named map, or an
+// empty string if the column is not read.
+func (s *Source) field(name, column string) string {
+	for field, col := range s.columns[name] {
+		if strings.EqualFold(col, column) {
+			return field
+		}
+	}
+	field := strings.ToLower(column)
+	if _, ok := s.columns[name][field]; ok {
+		// The field is read from another column
+		return ""
+	}
+	return field
+}
+
+// FillPasswdCache queries the passwd map and fills the passwd cache.
+func (s *Source) FillPasswdCache(c *cache.Cache) error {
+	return s.run("passwd", []string{"name", "uid", "gid"}, func(r row) error {
+		uid, err := r.uint32("uid")
+		if err != nil {
+			return err
+		}
+		gid, err := r.uint32("gid")
+		if err != nil {
+			return err
+		}
+		c.Add(&cache.PasswdEntry{
+			Name:   r.get("name"),
+			Passwd: r.get("passwd"),
+			UID:    uid,
+			GID:    gid,
+			GECOS:  r.get("gecos"),
+			Dir:    r.get("dir"),
+			Shell:  r.get("shell"),
+		})
+		return nil
+	})
+}
+
+// FillShadowCache queries the shadow map and fills the shadow cache.
+// NULL numeric fields are left unset.
+func (s *Source) FillShadowCache(c *cache.Cache) error {
+	return s.run("shadow", []string{"name"}, func(r row) error {
+		entry := cache.ShadowEntry{
+			Name:   r.get("name"),
+			Passwd: r.get("passwd"),
+		}
+		for _, f := range []struct {
+			field string
+			set   func(int32)
+		}{
+			{"lstchg", func(v int32) { entry.Lstchg = cache.Int32(v) }},
+			{"min", func(v int32) { entry.Min = cache.Int32(v) }},
+			{"max", func(v int32) { entry.Max = cache.Int32(v) }},
+			{"warn", func(v int32) { entry.Warn = cache.Int32(v) }},
+			{"inact", func(v int32) { entry.Inact = cache.Int32(v) }},
+			{"expire", func(v int32) { entry.Expire = cache.Int32(v) }},
+		} {
+			v, err := r.int32(f.field)
+			if err != nil {
+				return err
+			}
+			if v != nil {
+				f.set(*v)
+			}
+		}
+		if r.get("flag") != "" {
+			v, err := r.uint32("flag")
+			if err != nil {
+				return err
+			}
+			entry.Flag = cache.UInt32(v)
+		}
+		c.Add(&entry)
+		return nil
+	})
+}
+
+// FillGroupCache queries the group map and fills the group cache.  The
+// groups are added in the order of their first row.
+func (s *Source) FillGroupCache(c *cache.Cache) error {
+	var groups []*cache.GroupEntry
+	byName := map[string]*cache.GroupEntry{}
+	err := s.run("group", []string{"name", "gid"}, func(r row) error {
+		gid, err := r.uint32("gid")
+		if err != nil {
+			return err
+		}
+		g, ok := byName[r.get("name")]
+		if !ok {
+			g = &cache.GroupEntry{
+				Name:   r.get("name"),
+				Passwd: r.get("passwd"),
+				GID:    gid,
+			}
+			byName[g.Name] = g
+			groups = append(groups, g)
+		} else if g.GID != gid {
+			return errors.Errorf("conflicting gid in row %d of group for %s", r.n, g.Name)
+		}
+		g.Mem = append(g.Mem, s.members(r.get("mem"))...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		c.Add(g)
+	}
+	return nil
+}
+
+// members splits the members aggregated in a column.
+func (s *Source) members(v string) []string {
+	if s.memberSeparator == "" {
+		if v = strings.TrimSpace(v); v == "" {
+			return nil
+		}
+		return []string{v}
+	}
+	var mem []string
+	for _, m := range strings.Split(v, s.memberSeparator) {
+		if m = strings.TrimSpace(m); m != "" {
+			mem = append(mem, m)
+		}
+	}
+	return mem
+}
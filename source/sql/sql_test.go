@@ -0,0 +1,125 @@
+package sql
+
+import (
+	gosql "database/sql"
+	"testing"
+
+	"github.com/MiLk/nsscache-go/cache"
+	"github.com/MiLk/nsscache-go/source/internal/sourcetest"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+const schema = `
+CREATE TABLE users (
+	login TEXT NOT NULL,
+	uid INTEGER NOT NULL,
+	gid INTEGER NOT NULL,
+	fullname TEXT,
+	home TEXT,
+	shell TEXT,
+	hash TEXT,
+	changed INTEGER,
+	expire INTEGER
+);
+CREATE TABLE groups (name TEXT NOT NULL, gid INTEGER NOT NULL);
+CREATE TABLE members (grp TEXT NOT NULL, login TEXT NOT NULL);
+
+INSERT INTO users VALUES
+	('foo', 1000, 1000, 'Foo', '/home/foo', '/bin/bash', '$6$foo', 18000, NULL),
+	('bar', 1001, 1001, NULL, '/home/bar', '/bin/sh', NULL, NULL, 19000);
+INSERT INTO groups VALUES ('foo', 1000), ('bar', 1001), ('admins', 2000);
+INSERT INTO members VALUES ('admins', 'foo'), ('admins', 'bar'), ('foo', 'foo');
+`
+
+func openDB(t *testing.T) *gosql.DB {
+	db, err := gosql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSource(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	src, err := NewSource(db,
+		Query("passwd", `SELECT login AS name, 'x' AS passwd, uid, gid, fullname AS gecos, home AS dir, shell FROM users ORDER BY uid`),
+		Query("shadow", `SELECT login, hash, changed AS lstchg, expire FROM users WHERE uid >= ? ORDER BY uid`, 1000),
+		Column("shadow", "name", "login"),
+		Column("shadow", "passwd", "hash"),
+		Query("group", `SELECT g.name, g.gid, m.login AS mem FROM groups g LEFT JOIN members m ON m.grp = g.name ORDER BY g.gid, m.login`),
+	)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "foo:x:1000:1000:Foo:/home/foo:/bin/bash\nbar:x:1001:1001::/home/bar:/bin/sh\n", sourcetest.Fill(t, src.FillPasswdCache))
+	assert.Equal(t, "foo:$6$foo:18000::::::\nbar:!!::::::19000:\n", sourcetest.Fill(t, src.FillShadowCache))
+	assert.Equal(t, "foo:x:1000:foo\nbar:x:1001:\nadmins:x:2000:bar,foo\n", sourcetest.Fill(t, src.FillGroupCache))
+}
+
+func TestSource_AggregatedMembers(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	q := `SELECT g.name, g.gid, (SELECT group_concat(login, ?) FROM (SELECT login FROM members WHERE grp = g.name ORDER BY login)) AS mem FROM groups g ORDER BY g.gid`
+
+	src, err := NewSource(db, Query("group", q, ","))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:foo\nbar:x:1001:\nadmins:x:2000:bar,foo\n", sourcetest.Fill(t, src.FillGroupCache))
+
+	src, err = NewSource(db, Query("group", q, " "), MemberSeparator(" "))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:foo\nbar:x:1001:\nadmins:x:2000:bar,foo\n", sourcetest.Fill(t, src.FillGroupCache))
+
+	src, err = NewSource(db, Query("group", q, " "), MemberSeparator(""))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:foo\nbar:x:1001:\nadmins:x:2000:bar foo\n", sourcetest.Fill(t, src.FillGroupCache))
+}
+
+func TestSource_Errors(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+
+	src, err := NewSource(db,
+		Query("passwd", `SELECT login AS name, uid FROM users`),
+		Query("shadow", `SELECT login AS name, hash AS passwd, 'never' AS expire FROM users`),
+		Query("group", `SELECT name, gid FROM groups UNION ALL SELECT 'foo', 3000`),
+	)
+	assert.Nil(t, err)
+
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "no column for gid in the query of passwd", err.Error())
+	}
+	err = src.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "invalid expire in row 1 of shadow")
+	}
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "conflicting gid in row 4 of group for foo", err.Error())
+	}
+
+	src, err = NewSource(db,
+		Query("passwd", `SELECT login AS name, uid, NULL AS gid FROM users`),
+		Query("group", `SELECT * FROM missing`),
+	)
+	assert.Nil(t, err)
+	err = src.FillPasswdCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "null gid in row 1 of passwd", err.Error())
+	}
+	err = src.FillGroupCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "querying group: no such table: missing")
+	}
+	err = src.FillShadowCache(cache.NewCache())
+	if assert.NotNil(t, err) {
+		assert.Equal(t, "no query configured for shadow", err.Error())
+	}
+}
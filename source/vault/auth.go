@@ -0,0 +1,228 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// DefaultKubernetesTokenPath is the location where Kubernetes mounts
+// the service account token inside a pod.
+const DefaultKubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// reloginInterval is the time to wait before trying to log in again
+// after a failed attempt.
+var reloginInterval = 10 * time.Second
+
+// newLifetimeWatcher creates the watcher renewing the token.
+var newLifetimeWatcher = (*api.Client).NewLifetimeWatcher
+
+// loginFunc is an adapter to allow the use of ordinary functions as
+// Vault authentication methods.
+type loginFunc func(ctx context.Context, c *api.Client) (*api.Secret, error)
+
+// Login calls f(ctx, c).
+func (f loginFunc) Login(ctx context.Context, c *api.Client) (*api.Secret, error) {
+	return f(ctx, c)
+}
+
+func loginPath(mountPath, defaultMountPath string) string {
+	if mountPath == "" {
+		mountPath = defaultMountPath
+	}
+	return fmt.Sprintf("auth/%s/login", mountPath)
+}
+
+// AppRoleAuth returns an authentication method logging in with the
+// AppRole auth method mounted at mountPath, which defaults to
+// `approle` when empty.
+func AppRoleAuth(mountPath, roleID, secretID string) api.AuthMethod {
+	return loginFunc(func(ctx context.Context, c *api.Client) (*api.Secret, error) {
+		return c.Logical().WriteWithContext(ctx, loginPath(mountPath, "approle"), map[string]interface{}{
+			"role_id":   roleID,
+			"secret_id": secretID,
+		})
+	})
+}
+
+// KubernetesAuth returns an authentication method logging in with the
+// Kubernetes auth method mounted at mountPath, which defaults to
+// `kubernetes` when empty.
+//
+// The service account token is read from jwtPath on every login, so
+// that rotated tokens are picked up.  If jwtPath is empty,
+// DefaultKubernetesTokenPath is used.
+func KubernetesAuth(mountPath, role, jwtPath string) api.AuthMethod {
+	if jwtPath == "" {
+		jwtPath = DefaultKubernetesTokenPath
+	}
+	return loginFunc(func(ctx context.Context, c *api.Client) (*api.Secret, error) {
+		jwt, err := os.ReadFile(jwtPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading service account token")
+		}
+		return c.Logical().WriteWithContext(ctx, loginPath(mountPath, "kubernetes"), map[string]interface{}{
+			"role": role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		})
+	})
+}
+
+// CertAuth returns an authentication method logging in with the TLS
+// certificate auth method mounted at mountPath, which defaults to
+// `cert` when empty.
+//
+// If certFile and keyFile are empty, the client certificate configured
+// on the client, e.g. with VAULT_CLIENT_CERT and VAULT_CLIENT_KEY, is
+// used.  The name selects the certificate role to authenticate
+// against and may be empty to let Vault try all of them.
+func CertAuth(mountPath, name, certFile, keyFile string) api.AuthMethod {
+	return loginFunc(func(ctx context.Context, c *api.Client) (*api.Secret, error) {
+		if certFile != "" || keyFile != "" {
+			// Keep the CA, TLS and timeout settings of the client, on
+			// a copy of its transport that the certificate is added to.
+			cfg := c.CloneConfig()
+			if t, ok := cfg.HttpClient.Transport.(*http.Transport); ok {
+				cfg.HttpClient.Transport = t.Clone()
+			}
+			if err := cfg.ConfigureTLS(&api.TLSConfig{
+				ClientCert: certFile,
+				ClientKey:  keyFile,
+			}); err != nil {
+				return nil, errors.Wrap(err, "loading client certificate")
+			}
+			cl, err := api.NewClient(cfg)
+			if err != nil {
+				return nil, err
+			}
+			cl.SetHeaders(c.Headers())
+			c = cl
+		}
+		return c.Logical().WriteWithContext(ctx, loginPath(mountPath, "cert"), map[string]interface{}{
+			"name": name,
+		})
+	})
+}
+
+// TokenFileAuth returns an authentication method reading the token,
+// optionally response-wrapped, from the file at fpath.  This is
+// typically the sink of a Vault Agent.  The file is read again on
+// every login, so that tokens rotated by the agent are picked up.
+func TokenFileAuth(fpath string) api.AuthMethod {
+	return loginFunc(func(ctx context.Context, c *api.Client) (*api.Secret, error) {
+		token, err := readTokenFile(c, fpath)
+		if err != nil {
+			return nil, err
+		}
+
+		cl, err := c.Clone()
+		if err != nil {
+			return nil, err
+		}
+		cl.SetToken(token)
+		self, err := cl.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "looking up token")
+		}
+
+		ttl, err := self.TokenTTL()
+		if err != nil {
+			return nil, err
+		}
+		renewable, err := self.TokenIsRenewable()
+		if err != nil {
+			return nil, err
+		}
+		return &api.Secret{
+			Auth: &api.SecretAuth{
+				ClientToken:   token,
+				Renewable:     renewable,
+				LeaseDuration: int(ttl.Seconds()),
+			},
+		}, nil
+	})
+}
+
+// login authenticates the client with the source's authentication
+// method and returns the authentication secret.
+func (s *Source) login() (*api.Secret, error) {
+	secret, err := s.client.Auth().Login(s.ctx, s.auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "login to vault")
+	}
+	return secret, nil
+}
+
+// watch keeps the token of the source valid, renewing it while
+// possible and logging in again once the lease nears its expiry.  It
+// returns when the source is closed.
+func (s *Source) watch(secret *api.Secret) {
+	defer s.wg.Done()
+	for {
+		// Tokens without a TTL never expire.
+		if secret.Auth == nil || secret.Auth.LeaseDuration <= 0 {
+			return
+		}
+
+		w, err := newLifetimeWatcher(s.client, &api.LifetimeWatcherInput{
+			Secret: secret,
+		})
+		if err == nil {
+			stopped := make(chan struct{})
+			go func() {
+				w.Start()
+				close(stopped)
+			}()
+
+			select {
+			case <-s.ctx.Done():
+				w.Stop()
+				<-stopped
+				return
+			case <-w.DoneCh():
+			}
+			w.Stop()
+			<-stopped
+		} else if !s.wait() {
+			return
+		}
+
+		for {
+			secret, err = s.login()
+			if err == nil {
+				break
+			}
+			if !s.wait() {
+				return
+			}
+		}
+	}
+}
+
+// wait waits for reloginInterval and returns false if the source was
+// closed in the meantime.
+func (s *Source) wait() bool {
+	select {
+	case <-s.ctx.Done():
+		return false
+	case <-time.After(reloginInterval):
+		return true
+	}
+}
+
+// Close stops the background renewal of the token, cancelling any
+// login in progress, and waits for it to return.  It is a no-op when
+// the source was not created with an authentication method.
+func (s *Source) Close() error {
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+	}
+	return nil
+}
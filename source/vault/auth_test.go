@@ -0,0 +1,283 @@
+package vault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// authServer is a stand-in for the authentication endpoints of a
+// Vault server.
+type authServer struct {
+	mu     sync.Mutex
+	logins map[string][]map[string]interface{}
+	ttl    int
+}
+
+func (a *authServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/auth/token/lookup-self" {
+		if r.Header.Get("X-Vault-Token") != "file-token" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"ttl":%d,"renewable":false}}`, a.ttl)
+		return
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	a.mu.Lock()
+	a.logins[r.URL.Path] = append(a.logins[r.URL.Path], body)
+	n := len(a.logins[r.URL.Path])
+	a.mu.Unlock()
+
+	if body["secret_id"] == "invalid" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"errors":["invalid secret id"]}`)
+		return
+	}
+	fmt.Fprintf(w, `{"auth":{"client_token":"token-%d","renewable":false,"lease_duration":%d}}`, n, a.ttl)
+}
+
+func (a *authServer) count(path string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.logins[path])
+}
+
+func setupAuthTest(t *testing.T, ttl int) (*authServer, *api.Client, func()) {
+	a := &authServer{logins: map[string][]map[string]interface{}{}, ttl: ttl}
+	ts := httptest.NewServer(a)
+
+	cfg := api.DefaultConfig()
+	cfg.Address = ts.URL
+	cfg.MaxRetries = 0
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.ClearToken()
+
+	return a, client, ts.Close
+}
+
+func TestAppRoleAuth(t *testing.T) {
+	a, client, teardown := setupAuthTest(t, 0)
+	defer teardown()
+
+	s, err := NewSource(Client(client), Auth(AppRoleAuth("", "my-role", "my-secret")))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, "token-1", s.Client().Token())
+	assert.Equal(t, 1, a.count("/v1/auth/approle/login"))
+	assert.Equal(t, "my-role", a.logins["/v1/auth/approle/login"][0]["role_id"])
+	assert.Equal(t, "my-secret", a.logins["/v1/auth/approle/login"][0]["secret_id"])
+
+	_, err = NewSource(Client(client), Auth(AppRoleAuth("", "my-role", "invalid")))
+	assert.NotNil(t, err)
+}
+
+func TestKubernetesAuth(t *testing.T) {
+	a, client, teardown := setupAuthTest(t, 0)
+	defer teardown()
+
+	file, err := ioutil.TempFile("/tmp", "test-jwt-file")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("my-jwt\n")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	s, err := NewSource(Client(client), Auth(KubernetesAuth("k8s", "my-role", file.Name())))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, "token-1", s.Client().Token())
+	assert.Equal(t, 1, a.count("/v1/auth/k8s/login"))
+	assert.Equal(t, "my-role", a.logins["/v1/auth/k8s/login"][0]["role"])
+	assert.Equal(t, "my-jwt", a.logins["/v1/auth/k8s/login"][0]["jwt"])
+
+	_, err = NewSource(Client(client), Auth(KubernetesAuth("", "my-role", "/does/not/exist")))
+	assert.NotNil(t, err)
+}
+
+func TestCertAuth(t *testing.T) {
+	a, client, teardown := setupAuthTest(t, 0)
+	defer teardown()
+
+	s, err := NewSource(Client(client), Auth(CertAuth("", "web", "", "")))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, "token-1", s.Client().Token())
+	assert.Equal(t, 1, a.count("/v1/auth/cert/login"))
+	assert.Equal(t, "web", a.logins["/v1/auth/cert/login"][0]["name"])
+
+	_, err = NewSource(Client(client), Auth(CertAuth("", "web", "/does/not/exist", "/does/not/exist")))
+	assert.NotNil(t, err)
+}
+
+// writeClientCert writes a self-signed client certificate and its key
+// to files in dir.
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "web"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.Nil(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestCertAuth_Files(t *testing.T) {
+	a := &authServer{logins: map[string][]map[string]interface{}{}}
+	var peers []string
+	var namespaces []string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, c := range r.TLS.PeerCertificates {
+			peers = append(peers, c.Subject.CommonName)
+		}
+		namespaces = append(namespaces, r.Header.Get("X-Vault-Namespace"))
+		a.ServeHTTP(w, r)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// The client trusts the private CA of the server.
+	cfg := api.DefaultConfig()
+	cfg.Address = ts.URL
+	cfg.MaxRetries = 0
+	assert.Nil(t, cfg.ConfigureTLS(&api.TLSConfig{
+		CACertBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}),
+	}))
+	client, err := api.NewClient(cfg)
+	assert.Nil(t, err)
+	client.ClearToken()
+	client.SetNamespace("team")
+
+	certFile, keyFile := writeClientCert(t, t.TempDir())
+	s, err := NewSource(Client(client), Auth(CertAuth("", "web", certFile, keyFile)))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	assert.Equal(t, "token-1", s.Client().Token())
+	assert.Equal(t, []string{"web"}, peers)
+	assert.Equal(t, []string{"team"}, namespaces)
+
+	// The certificate is not added to the configured client.
+	tlsConfig := client.CloneConfig().HttpClient.Transport.(*http.Transport).TLSClientConfig
+	assert.Nil(t, tlsConfig.GetClientCertificate)
+}
+
+func TestTokenFileAuth(t *testing.T) {
+	_, client, teardown := setupAuthTest(t, 0)
+	defer teardown()
+
+	file, err := ioutil.TempFile("/tmp", "test-token-file")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString("file-token")
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	s, err := NewSource(Client(client), Auth(TokenFileAuth(file.Name())))
+	assert.Nil(t, err)
+	defer s.Close()
+	assert.Equal(t, "file-token", s.Client().Token())
+
+	assert.Nil(t, ioutil.WriteFile(file.Name(), []byte("other-token"), 0600))
+	_, err = NewSource(Client(client), Auth(TokenFileAuth(file.Name())))
+	assert.NotNil(t, err)
+}
+
+func TestSource_Relogin(t *testing.T) {
+	a, client, teardown := setupAuthTest(t, 1)
+	defer teardown()
+
+	s, err := NewSource(Client(client), Auth(AppRoleAuth("", "my-role", "my-secret")))
+	assert.Nil(t, err)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for a.count("/v1/auth/approle/login") < 3 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Nil(t, s.Close())
+	assert.Nil(t, s.Close())
+
+	n := a.count("/v1/auth/approle/login")
+	assert.True(t, n >= 3, "expected at least 3 logins, got %d", n)
+	assert.NotEqual(t, "token-1", s.Client().Token())
+}
+
+func TestSource_WatcherError(t *testing.T) {
+	a, client, teardown := setupAuthTest(t, 1)
+	defer teardown()
+
+	defer func(f func(*api.Client, *api.LifetimeWatcherInput) (*api.LifetimeWatcher, error), d time.Duration) {
+		newLifetimeWatcher, reloginInterval = f, d
+	}(newLifetimeWatcher, reloginInterval)
+	reloginInterval = 10 * time.Millisecond
+	var mu sync.Mutex
+	failures := 0
+	newLifetimeWatcher = func(c *api.Client, i *api.LifetimeWatcherInput) (*api.LifetimeWatcher, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures < 2 {
+			failures++
+			return nil, fmt.Errorf("watcher error")
+		}
+		return c.NewLifetimeWatcher(i)
+	}
+
+	s, err := NewSource(Client(client), Auth(AppRoleAuth("", "my-role", "my-secret")))
+	assert.Nil(t, err)
+	defer s.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for a.count("/v1/auth/approle/login") < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, a.count("/v1/auth/approle/login") >= 4)
+
+	// No login happens once the source is closed
+	assert.Nil(t, s.Close())
+	n := a.count("/v1/auth/approle/login")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, n, a.count("/v1/auth/approle/login"))
+}
@@ -38,13 +38,26 @@ func CreateVaultClient(fpath string) (*api.Client, error) {
 		return nil, err
 	}
 
-	rawToken, err := ReadToken(fpath)
+	token, err := readTokenFile(client, fpath)
 	if err != nil {
 		return nil, err
 	}
 
+	client.SetToken(token)
+	return client, nil
+}
+
+// readTokenFile returns the token stored in the file at fpath.  If the
+// file contains a response-wrapped token, it is unwrapped using the
+// provided client.
+func readTokenFile(client *api.Client, fpath string) (string, error) {
+	rawToken, err := ReadToken(fpath)
+	if err != nil {
+		return "", err
+	}
+
 	if len(rawToken) == 0 {
-		return nil, errors.New("token file is empty")
+		return "", errors.New("token file is empty")
 	}
 
 	var wrappedData wrappedData
@@ -55,37 +68,36 @@ func CreateVaultClient(fpath string) (*api.Client, error) {
 		if err := json.Unmarshal(rawToken, &wrappedData); err == nil {
 			unwrapToken := wrappedData.Token
 			if unwrapToken == "" {
-				return nil, errors.New("unwrap token is empty")
+				return "", errors.New("unwrap token is empty")
 			}
 
 			secret, err := client.Logical().Unwrap(unwrapToken)
 			if err != nil {
-				return nil, err
+				return "", err
 			}
 
 			if secret == nil {
-				return nil, errors.New("could not find wrapped response")
+				return "", errors.New("could not find wrapped response")
 			}
 
 			dataToken, ok := secret.Data["token"].(string)
 			if !ok {
-				return nil, errors.New("key `token` was not found on the unwrapped data")
+				return "", errors.New("key `token` was not found on the unwrapped data")
 			}
 
 			token = dataToken
 		} else {
-			return nil, err
+			return "", err
 		}
 	} else {
 		token = string(rawToken)
 	}
 
 	if token == "" {
-		return nil, errors.New("unable to fetch token from file")
+		return "", errors.New("unable to fetch token from file")
 	}
 
-	client.SetToken(token)
-	return client, nil
+	return token, nil
 }
 
 // ReadToken returns a byte array containing data from the designated file.
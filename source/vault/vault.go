@@ -4,9 +4,14 @@ package vault
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
@@ -17,9 +22,21 @@ import (
 // Source contains the Vault API client and complete path to the cache
 // data within the vault.
 type Source struct {
-	client    *api.Client
-	prefix    string
-	mountPath string
+	client     *api.Client
+	prefix     string
+	mountPath  string
+	aggregated bool
+	version    int
+	pinned     map[string]int
+	asOf       time.Time
+
+	mu       sync.Mutex
+	versions map[string]int
+
+	auth   api.AuthMethod
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // Option represents a function which will make some change to the
@@ -44,6 +61,54 @@ func MountPath(m string) Option {
 	return func(s *Source) { s.mountPath = m }
 }
 
+// Aggregated is an option function which will make the source read a
+// single secret per cache, e.g. `<prefix>/passwd`, containing the JSON
+// array of all the entries, instead of one secret per entry.  Such
+// secrets can be written with Publish.
+func Aggregated() Option {
+	return func(s *Source) { s.aggregated = true }
+}
+
+// Version is an option function which will make the source read the
+// given version of the secrets instead of the latest one, in
+// aggregated mode.  Reading fails if a secret does not have that
+// version.  Every secret has its own versions in per-entry mode, where
+// Pinned is used instead.
+func Version(v int) Option {
+	return func(s *Source) { s.version = v }
+}
+
+// Pinned is an option function which will make the source read the
+// given version of every secret, keyed as in the map returned by
+// Versions, e.g. to reproduce a previous fill.  Only the secrets in
+// the map are read, and reading fails if one of them does not have its
+// version anymore, or if the map has no version for the cache filled,
+// rather than leaving the cache empty.
+func Pinned(versions map[string]int) Option {
+	return func(s *Source) {
+		s.pinned = make(map[string]int, len(versions))
+		for k, v := range versions {
+			s.pinned[k] = v
+		}
+	}
+}
+
+// AsOf is an option function which will make the source read the
+// secrets as they were at the given time, according to the creation
+// time of their versions.  Secrets which did not exist, or were
+// deleted, at that time are skipped.
+func AsOf(t time.Time) Option {
+	return func(s *Source) { s.asOf = t }
+}
+
+// Auth is an option function which will make the source log in with
+// the provided authentication method.  The token obtained is renewed
+// in the background, and the source logs in again when its lease
+// nears expiry, until the source is closed.
+func Auth(m api.AuthMethod) Option {
+	return func(s *Source) { s.auth = m }
+}
+
 // NewSource creates a new Vault source using the options provided.
 // If no options are provided a client is initialized with the default
 // values.
@@ -57,6 +122,10 @@ func NewSource(opts ...Option) (*Source, error) {
 		opt(&s)
 	}
 
+	if s.version != 0 && !s.aggregated {
+		return nil, errors.New("a single version requires the aggregated mode, pin every secret instead")
+	}
+
 	if s.client == nil {
 		cl, err := api.NewClient(nil)
 		if err != nil {
@@ -65,6 +134,17 @@ func NewSource(opts ...Option) (*Source, error) {
 		s.client = cl
 	}
 
+	if s.auth != nil {
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		secret, err := s.login()
+		if err != nil {
+			s.cancel()
+			return nil, err
+		}
+		s.wg.Add(1)
+		go s.watch(secret)
+	}
+
 	return &s, nil
 }
 
@@ -74,41 +154,160 @@ func (s *Source) Client() *api.Client {
 	return s.client
 }
 
+func (s *Source) fill(name string, c *cache.Cache, createEntry func() cache.Entry) error {
+	s.resetVersions(name)
+	if s.aggregated {
+		return s.read(name, c, createEntry)
+	}
+	return s.list(name, c, createEntry)
+}
+
 func (s *Source) list(name string, c *cache.Cache, createEntry func() cache.Entry) error {
 	prefix := fmt.Sprintf("%s/%s", s.prefix, name)
+	keys, err := s.keys(name, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		key := fmt.Sprintf("%s/%s", name, k)
+		sec, err := s.readSecret(fmt.Sprintf("%s/%s", prefix, k), s.pinned[key])
+		if err != nil {
+			return errors.Wrap(err, "read from vault")
+		}
+
+		// The secret did not exist at the requested time
+		if sec == nil && !s.asOf.IsZero() {
+			continue
+		}
+
+		s.recordVersion(key, sec)
+		e := createEntry()
+		if err := decodeValue(sec, e); err != nil {
+			return err
+		}
+		c.Add(e)
+	}
+	return nil
+}
+
+// keys returns the keys of the secrets of the given cache, which are
+// the pinned ones if the versions were pinned.
+func (s *Source) keys(name, prefix string) ([]string, error) {
+	if s.pinned != nil {
+		var keys []string
+		for k := range s.pinned {
+			if strings.HasPrefix(k, name+"/") {
+				keys = append(keys, strings.TrimPrefix(k, name+"/"))
+			}
+		}
+		if len(keys) == 0 {
+			return nil, errors.Errorf("no pinned version for %s", name)
+		}
+		sort.Strings(keys)
+		return keys, nil
+	}
+
 	sec, err := s.client.Logical().List(fmt.Sprintf("%s/metadata/%s", s.mountPath, prefix))
 	if err != nil {
-		return errors.Wrap(err, "list from vault")
+		return nil, errors.Wrap(err, "list from vault")
 	}
 
 	// No secret at that path
 	if sec == nil {
-		return nil
+		return nil, nil
 	}
 
-	keys := sec.Data["keys"].([]interface{})
-	for _, k := range keys {
-		sec, err := s.client.Logical().Read(fmt.Sprintf("%s/data/%s/%s", s.mountPath, prefix, k))
-		if err != nil {
-			return errors.Wrap(err, "read from vault")
+	var keys []string
+	for _, k := range sec.Data["keys"].([]interface{}) {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	return keys, nil
+}
+
+// read fills the cache from a single secret containing all the
+// entries of the cache.
+func (s *Source) read(name string, c *cache.Cache, createEntry func() cache.Entry) error {
+	version := s.version
+	if s.pinned != nil {
+		v, ok := s.pinned[name]
+		if !ok {
+			return errors.Errorf("no pinned version for %s", name)
 		}
-		value := sec.Data["data"].(map[string]interface{})["value"].(string)
-		b := bytes.NewBufferString(value)
-		b64 := base64.NewDecoder(base64.StdEncoding, b)
+		version = v
+	}
+
+	sec, err := s.readSecret(fmt.Sprintf("%s/%s", s.prefix, name), version)
+	if err != nil {
+		return errors.Wrap(err, "read from vault")
+	}
+
+	// No secret at that path
+	if sec == nil {
+		return nil
+	}
+
+	s.recordVersion(name, sec)
+
+	var raw []json.RawMessage
+	if err := decodeValue(sec, &raw); err != nil {
+		return err
+	}
+	for i, r := range raw {
 		e := createEntry()
-		err = json.NewDecoder(b64).Decode(e)
-		if err != nil {
-			return errors.Wrap(err, "json decoding")
+		if err := json.Unmarshal(r, e); err != nil {
+			return errors.Wrapf(err, "json decoding entry %d", i)
 		}
 		c.Add(e)
 	}
 	return nil
 }
 
+// decodeValue decodes the base64 encoded JSON document stored in the
+// `value` field of a key/value secret into v.
+func decodeValue(sec *api.Secret, v interface{}) error {
+	if sec == nil {
+		return errors.New("secret not found")
+	}
+	data, ok := sec.Data["data"].(map[string]interface{})
+	if !ok {
+		return errors.New("secret has no data")
+	}
+	value, ok := data["value"].(string)
+	if !ok {
+		return errors.New("secret has no value")
+	}
+	b := bytes.NewBufferString(value)
+	b64 := base64.NewDecoder(base64.StdEncoding, b)
+	if err := json.NewDecoder(b64).Decode(v); err != nil {
+		return errors.Wrap(err, "json decoding")
+	}
+	return nil
+}
+
+// Publish writes all the entries of the cache as a single secret
+// under the given name, e.g. `passwd`, in the format read by the
+// source when the Aggregated option is used.
+func (s *Source) Publish(name string, c *cache.Cache) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "json encoding")
+	}
+	_, err = s.client.Logical().Write(fmt.Sprintf("%s/data/%s/%s", s.mountPath, s.prefix, name), map[string]interface{}{
+		"data": map[string]interface{}{
+			"value": base64.StdEncoding.EncodeToString(b),
+		},
+	})
+	if err != nil {
+		return errors.Wrap(err, "write to vault")
+	}
+	return nil
+}
+
 // FillPasswdCache reads entries from the Vault and uses them to fill
 // the passwd cache.
 func (s *Source) FillPasswdCache(c *cache.Cache) error {
-	return s.list("passwd", c, func() cache.Entry {
+	return s.fill("passwd", c, func() cache.Entry {
 		return &cache.PasswdEntry{}
 	})
 }
@@ -116,7 +315,7 @@ func (s *Source) FillPasswdCache(c *cache.Cache) error {
 // FillShadowCache reads entries from the Vault and uses them to fill
 // the shadow cache.
 func (s *Source) FillShadowCache(c *cache.Cache) error {
-	return s.list("shadow", c, func() cache.Entry {
+	return s.fill("shadow", c, func() cache.Entry {
 		return &cache.ShadowEntry{}
 	})
 }
@@ -124,7 +323,7 @@ func (s *Source) FillShadowCache(c *cache.Cache) error {
 // FillGroupCache reads entries from the Vault and uses them to fill
 // the group cache.
 func (s *Source) FillGroupCache(c *cache.Cache) error {
-	return s.list("group", c, func() cache.Entry {
+	return s.fill("group", c, func() cache.Entry {
 		return &cache.GroupEntry{}
 	})
 }
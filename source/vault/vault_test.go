@@ -2,12 +2,14 @@ package vault
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	kv "github.com/hashicorp/vault-plugin-secrets-kv"
 	"github.com/hashicorp/vault/api"
@@ -239,3 +241,195 @@ func TestSource_List(t *testing.T) {
 	err = s.list("name", nil, nil)
 	assert.NotNil(t, err)
 }
+
+func TestSource_Aggregated(t *testing.T) {
+	teardownTest := setupTest(t)
+	defer teardownTest(t)
+
+	s, err := NewSource(Client(vaultClient), Prefix("nsscache-aggregated"), Aggregated())
+	assert.Nil(t, err)
+
+	// Empty path
+	c := cache.NewCache()
+	assert.Nil(t, s.FillPasswdCache(c))
+	var b bytes.Buffer
+	n, err := c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, n)
+
+	c = cache.NewCache()
+	c.Add(&cache.PasswdEntry{
+		Name:   "foo",
+		Passwd: "x",
+		UID:    1000,
+		GID:    1000,
+		GECOS:  "Mr Foo",
+		Dir:    "/home/foo",
+		Shell:  "/bin/bash",
+	}, &cache.PasswdEntry{
+		Name:   "bar",
+		Passwd: "x",
+		UID:    1001,
+		GID:    1000,
+		GECOS:  "Mrs Bar",
+		Dir:    "/home/bar",
+		Shell:  "/bin/bash",
+	})
+	assert.Nil(t, s.Publish("passwd", c))
+
+	g := cache.NewCache()
+	g.Add(&cache.GroupEntry{
+		Name: "foo",
+		GID:  1000,
+		Mem:  []string{"foo", "bar"},
+	})
+	assert.Nil(t, s.Publish("group", g))
+
+	c = cache.NewCache()
+	assert.Nil(t, s.FillPasswdCache(c))
+	b.Reset()
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	expected := `foo:x:1000:1000:Mr Foo:/home/foo:/bin/bash
+bar:x:1001:1000:Mrs Bar:/home/bar:/bin/bash
+`
+	assert.Equal(t, expected, b.String())
+
+	c = cache.NewCache()
+	assert.Nil(t, s.FillGroupCache(c))
+	b.Reset()
+	_, err = c.WriteTo(&b)
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:foo,bar\n", b.String())
+
+	// Entry format error
+	_, err = vaultClient.Logical().Write("secret/data/nsscache-aggregated/shadow", map[string]interface{}{
+		"data": map[string]interface{}{
+			"value": base64.StdEncoding.EncodeToString([]byte(`[{"name": "foo", "min": "ninety"}]`)),
+		},
+	})
+	assert.Nil(t, err)
+
+	c = cache.NewCache()
+	err = s.FillShadowCache(c)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "json decoding entry 0")
+}
+
+func TestSource_Version(t *testing.T) {
+	teardownTest := setupTest(t)
+	defer teardownTest(t)
+
+	mountPath := "secret"
+	prefix := fmt.Sprintf("%s/%s", "nsscache-version", "group")
+	entry := cache.GroupEntry{
+		Name: "foo",
+		GID:  1000,
+	}
+	assert.Nil(t, addEntry(vaultClient, mountPath, prefix, entry.Name, &entry))
+	time.Sleep(10 * time.Millisecond)
+	first := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	entry.Mem = []string{"foo"}
+	assert.Nil(t, addEntry(vaultClient, mountPath, prefix, entry.Name, &entry))
+	entry.Name = "bar"
+	entry.GID = 1001
+	assert.Nil(t, addEntry(vaultClient, mountPath, prefix, entry.Name, &entry))
+
+	fill := func(opts ...Option) (string, map[string]int, error) {
+		s, err := NewSource(append(opts, Client(vaultClient), Prefix("nsscache-version"))...)
+		if err != nil {
+			return "", nil, err
+		}
+		c := cache.NewCache()
+		if err := s.FillGroupCache(c); err != nil {
+			return "", nil, err
+		}
+		var b bytes.Buffer
+		_, err = c.WriteTo(&b)
+		assert.Nil(t, err)
+		return b.String(), s.Versions(), nil
+	}
+
+	out, versions, err := fill()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar:x:1001:foo\nfoo:x:1000:foo\n", out)
+	assert.Equal(t, map[string]int{"group/bar": 1, "group/foo": 2}, versions)
+
+	out, versions, err = fill(AsOf(first))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", out)
+	assert.Equal(t, map[string]int{"group/foo": 1}, versions)
+
+	out, versions, err = fill(Pinned(map[string]int{"group/bar": 1, "group/foo": 1}))
+	assert.Nil(t, err)
+	assert.Equal(t, "bar:x:1001:foo\nfoo:x:1000:\n", out)
+	assert.Equal(t, map[string]int{"group/bar": 1, "group/foo": 1}, versions)
+
+	// Only the pinned secrets are read.
+	out, _, err = fill(Pinned(map[string]int{"group/foo": 2}))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:foo\n", out)
+
+	_, _, err = fill(Pinned(map[string]int{"group/bar": 2, "group/foo": 1}))
+	assert.NotNil(t, err)
+
+	// The group cache is not left empty without a pinned version.
+	_, _, err = fill(Pinned(map[string]int{"passwd/foo": 1}))
+	assert.EqualError(t, err, "no pinned version for group")
+
+	_, _, err = fill(Version(1))
+	assert.EqualError(t, err, "a single version requires the aggregated mode, pin every secret instead")
+
+	// The version current at that time was destroyed since.
+	assert.Nil(t, vaultClient.KVv2(mountPath).Destroy(context.Background(), prefix+"/foo", []int{1}))
+	_, _, err = fill(AsOf(first))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "version 1 of nsscache-version/group/foo was destroyed")
+	out, _, err = fill()
+	assert.Nil(t, err)
+	assert.Equal(t, "bar:x:1001:foo\nfoo:x:1000:foo\n", out)
+}
+
+func TestSource_Version_Aggregated(t *testing.T) {
+	teardownTest := setupTest(t)
+	defer teardownTest(t)
+
+	for _, value := range []string{`[{"name": "foo", "gid": 1000}]`, `[{"name": "foo", "gid": 1000, "mem": ["foo"]}]`} {
+		_, err := vaultClient.Logical().Write("secret/data/nsscache-version-aggregated/group", map[string]interface{}{
+			"data": map[string]interface{}{
+				"value": base64.StdEncoding.EncodeToString([]byte(value)),
+			},
+		})
+		assert.Nil(t, err)
+	}
+
+	fill := func(opts ...Option) (string, error) {
+		s, err := NewSource(append(opts, Client(vaultClient), Prefix("nsscache-version-aggregated"), Aggregated())...)
+		assert.Nil(t, err)
+		c := cache.NewCache()
+		if err := s.FillGroupCache(c); err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		_, err = c.WriteTo(&b)
+		assert.Nil(t, err)
+		return b.String(), nil
+	}
+
+	out, err := fill(Version(1))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:\n", out)
+
+	out, err = fill(Pinned(map[string]int{"group": 2}))
+	assert.Nil(t, err)
+	assert.Equal(t, "foo:x:1000:foo\n", out)
+
+	_, err = fill(Version(3))
+	assert.NotNil(t, err)
+
+	// e.g. the versions of a per-entry source
+	_, err = fill(Pinned(map[string]int{"group/foo": 2}))
+	assert.EqualError(t, err, "no pinned version for group")
+}
@@ -0,0 +1,130 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// readSecret reads the given version of the secret at the given path,
+// relative to the mount path of the key/value store, or the version
+// selected by the AsOf option if version is 0.  A nil secret is
+// returned when no secret exists at that path.
+func (s *Source) readSecret(path string, version int) (*api.Secret, error) {
+	dataPath := fmt.Sprintf("%s/data/%s", s.mountPath, path)
+
+	if version == 0 && !s.asOf.IsZero() {
+		v, err := s.versionAt(path)
+		if err != nil || v == 0 {
+			return nil, err
+		}
+		version = v
+	}
+
+	if version == 0 {
+		return s.client.Logical().Read(dataPath)
+	}
+
+	sec, err := s.client.Logical().ReadWithData(dataPath, map[string][]string{
+		"version": {strconv.Itoa(version)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sec == nil {
+		return nil, errors.Errorf("version %d of %s not found", version, path)
+	}
+	return sec, nil
+}
+
+// versionAt returns the version of the secret at the given path which
+// was current at the time set with the AsOf option, or 0 if there was
+// none.  The secret cannot be read as it was if that version has been
+// destroyed since, which is an error.
+func (s *Source) versionAt(path string) (int, error) {
+	versions, err := s.client.KVv2(s.mountPath).GetVersionsAsList(context.Background(), path)
+	if errors.Is(err, api.ErrSecretNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	version, destroyed := 0, false
+	for _, v := range versions {
+		if v.CreatedTime.After(s.asOf) {
+			break
+		}
+		if !v.DeletionTime.IsZero() && !v.DeletionTime.After(s.asOf) {
+			version, destroyed = 0, false
+		} else {
+			version, destroyed = v.Version, v.Destroyed
+		}
+	}
+	if destroyed {
+		return 0, errors.Errorf("version %d of %s was destroyed", version, path)
+	}
+	return version, nil
+}
+
+// recordVersion remembers the version of the secret read for the given
+// key.
+func (s *Source) recordVersion(key string, sec *api.Secret) {
+	if sec == nil {
+		return
+	}
+	metadata, ok := sec.Data["metadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	var version int
+	switch v := metadata["version"].(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return
+		}
+		version = int(n)
+	case float64:
+		version = int(v)
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.versions == nil {
+		s.versions = map[string]int{}
+	}
+	s.versions[key] = version
+}
+
+// resetVersions forgets the versions recorded for the given cache.
+func (s *Source) resetVersions(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.versions {
+		if k == name || strings.HasPrefix(k, name+"/") {
+			delete(s.versions, k)
+		}
+	}
+}
+
+// Versions returns the version of every secret used during the last
+// fill of each cache.  The keys are the paths of the secrets relative
+// to the prefix, e.g. `passwd/foo`, or `passwd` in aggregated mode.
+func (s *Source) Versions() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := make(map[string]int, len(s.versions))
+	for k, v := range s.versions {
+		versions[k] = v
+	}
+	return versions
+}